@@ -0,0 +1,338 @@
+package lisp
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+/*
+	Actors
+*/
+
+// An actor is a thread with a mailbox. Messages are taken out of the mailbox
+// by receive, which may skip over messages that it isn't interested in.
+type Actor struct {
+	name     string
+	lock     sync.Mutex
+	mail     []interface{}
+	signal   chan struct{}
+	dead     bool
+	monitors []*Actor
+	links    []*Actor
+}
+
+func newActor(name string) *Actor {
+	return &Actor{name: name, signal: make(chan struct{}, 1)}
+}
+
+// Starts f on a new thread, started by th, that receives from the returned
+// actor's mailbox.
+func SpawnActor(th *Thread, f Function, args interface{}) *Actor {
+	t := newThread(th.ctx, th.params)
+	res := newActor(t.name)
+	t.actor = res
+	t.start(threadPrimitive(func(th *Thread, _ interface{}) interface{} {
+		defer func() {
+			if err := recover(); err != nil {
+				res.exit(err)
+				panic(err)
+			}
+		}()
+		v := applyOn(th, f, args)
+		res.exit(Symbol("normal"))
+		return v
+	}), EMPTY_LIST)
+	return res
+}
+
+// The actor for th. A thread that was not started as an actor is given one
+// the first time it asks.
+func currentActor(th *Thread) *Actor {
+	if th.actor == nil {
+		th.actor = newActor(th.name)
+	}
+	return th.actor
+}
+
+// Adds msg to the mailbox. Messages sent to an actor that has finished are
+// dropped.
+func (self *Actor) Send(msg interface{}) {
+	self.lock.Lock()
+	if !self.dead {
+		self.mail = append(self.mail, msg)
+	}
+	self.lock.Unlock()
+	select {
+	case self.signal <- struct{}{}:
+	default:
+	}
+}
+
+// Takes the first message that match accepts out of the mailbox, waiting up
+// to timeout for one to arrive, unless th is cancelled first. A negative
+// timeout waits forever. Returns whatever match returned, and whether a
+// message was found.
+func (self *Actor) Receive(th *Thread, match func(msg interface{}) interface{}, timeout time.Duration) (interface{}, bool) {
+	ctx := th.ctx
+	var expired <-chan time.Time
+	if timeout >= 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	for i := 0; ; {
+		self.lock.Lock()
+		if i < len(self.mail) {
+			msg := self.mail[i]
+			self.lock.Unlock()
+			if res := match(msg); res != false {
+				self.lock.Lock()
+				self.mail = append(self.mail[:i], self.mail[i+1:]...)
+				self.lock.Unlock()
+				return res, true
+			}
+			i++
+			continue
+		}
+		self.lock.Unlock()
+		select {
+		case <-self.signal:
+		case <-expired:
+			return nil, false
+		case <-ctx.Done():
+			checkCancelled(ctx)
+		}
+	}
+}
+
+// Asks to be sent (down actor reason) when the actor finishes.
+func (self *Actor) Monitor(by *Actor) {
+	self.lock.Lock()
+	dead := self.dead
+	if !dead {
+		self.monitors = append(self.monitors, by)
+	}
+	self.lock.Unlock()
+	if dead {
+		by.Send(List(Symbol("down"), self, Symbol("noproc")))
+	}
+}
+
+// Links two actors, so that if either fails the other is sent
+// (exit actor reason).
+func (self *Actor) Link(other *Actor) {
+	self.addLink(other)
+	if !other.addLink(self) {
+		self.Send(List(Symbol("exit"), other, Symbol("noproc")))
+	}
+}
+
+func (self *Actor) addLink(other *Actor) bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.dead {
+		return false
+	}
+	self.links = append(self.links, other)
+	return true
+}
+
+func (self *Actor) Alive() bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return !self.dead
+}
+
+func (self *Actor) exit(reason interface{}) {
+	self.lock.Lock()
+	self.dead = true
+	self.mail = nil
+	monitors, links := self.monitors, self.links
+	self.lock.Unlock()
+	for _, m := range monitors {
+		m.Send(List(Symbol("down"), self, reason))
+	}
+	if reason == Symbol("normal") {
+		return
+	}
+	for _, l := range links {
+		l.Send(List(Symbol("exit"), self, reason))
+	}
+}
+
+func (self *Actor) String() string {
+	return self.GoString()
+}
+
+func (self *Actor) GoString() string {
+	return fmt.Sprintf("#<actor %s>", self.name)
+}
+
+/*
+	Patterns
+
+	A symbol matches anything and binds it, except for _ which just matches
+	anything. Quoted data, and any other atom, matches things equal to it.
+	Pairs and vectors match element by element.
+*/
+
+func matchPattern(pat, x interface{}, binds []interface{}) ([]interface{}, bool) {
+	switch p := pat.(type) {
+	case Symbol:
+		if p == "_" {
+			return binds, true
+		}
+		return append(binds, x), true
+	case *Pair:
+		if p.a == Symbol("quote") {
+			return binds, equal(Car(p.d), x)
+		}
+		xp, ok := x.(*Pair)
+		if !ok {
+			return binds, false
+		}
+		binds, ok = matchPattern(p.a, xp.a, binds)
+		if !ok {
+			return binds, false
+		}
+		return matchPattern(p.d, xp.d, binds)
+	case Vector:
+		xv, ok := x.(Vector)
+		if !ok || len(xv) != len(p) {
+			return binds, false
+		}
+		for i := range p {
+			binds, ok = matchPattern(p[i], xv[i], binds)
+			if !ok {
+				return binds, false
+			}
+		}
+		return binds, true
+	}
+	return binds, equal(pat, x)
+}
+
+func patternVariables(pat interface{}, vars []interface{}) []interface{} {
+	switch p := pat.(type) {
+	case Symbol:
+		if p != "_" {
+			vars = append(vars, p)
+		}
+	case *Pair:
+		if p.a != Symbol("quote") {
+			vars = patternVariables(p.d, patternVariables(p.a, vars))
+		}
+	case Vector:
+		for _, x := range p {
+			vars = patternVariables(x, vars)
+		}
+	}
+	return vars
+}
+
+func equal(a, b interface{}) bool {
+	switch x := a.(type) {
+	case *Pair:
+		y, ok := b.(*Pair)
+		return ok && equal(x.a, y.a) && equal(x.d, y.d)
+	case Vector:
+		y, ok := b.(Vector)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for i := range x {
+			if !equal(x[i], y[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return True(eqv(a, b))
+}
+
+/*
+	Actor primitives
+*/
+
+func toActor(addr interface{}) *Actor {
+	a, ok := addr.(*Actor)
+	if !ok {
+		TypeError("actor", addr)
+	}
+	return a
+}
+
+func spawnActor(th *Thread, f, args interface{}) interface{} {
+	fn, ok := f.(Function)
+	if !ok {
+		TypeError("function", f)
+	}
+	return SpawnActor(th, fn, args)
+}
+
+func actorSelf(th *Thread) interface{} {
+	return currentActor(th)
+}
+
+func actorSend(addr, msg interface{}) interface{} {
+	toActor(addr).Send(msg)
+	return nil
+}
+
+// Called by receive with a function that maps a message to a thunk for the
+// clause it matches, or #f if it matches none.
+func actorReceive(th *Thread, match, timeout, expired interface{}) interface{} {
+	m, ok := match.(Function)
+	if !ok {
+		TypeError("function", match)
+	}
+	wait := time.Duration(-1)
+	if timeout != false {
+		ms, ok := timeout.(int)
+		if !ok {
+			TypeError("fixnum", timeout)
+		}
+		wait = time.Duration(ms) * time.Millisecond
+	}
+	thk, found := currentActor(th).Receive(th, func(msg interface{}) interface{} {
+		return callOn(th, m, msg)
+	}, wait)
+	if !found {
+		if expired == false {
+			return nil
+		}
+		thk = expired
+	}
+	f, ok := thk.(Function)
+	if !ok {
+		TypeError("function", thk)
+	}
+	return callOn(th, f)
+}
+
+func actorMonitor(th *Thread, addr interface{}) interface{} {
+	toActor(addr).Monitor(currentActor(th))
+	return nil
+}
+
+func actorLink(th *Thread, addr interface{}) interface{} {
+	currentActor(th).Link(toActor(addr))
+	return nil
+}
+
+func actorAlive(addr interface{}) interface{} {
+	return toActor(addr).Alive()
+}
+
+func patternMatch(pat, x interface{}) interface{} {
+	binds, ok := matchPattern(pat, x, nil)
+	if !ok {
+		return false
+	}
+	return vecToLs(Vector(binds))
+}
+
+func patternVars(pat interface{}) interface{} {
+	return vecToLs(Vector(patternVariables(pat, nil)))
+}
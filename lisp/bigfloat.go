@@ -0,0 +1,157 @@
+package lisp
+
+import (
+	"math"
+	"math/big"
+	"strings"
+)
+
+/*
+	Bigfloats
+
+	Floating point numbers with as much precision as asked for. The results
+	of arithmetic on them have the precision and rounding mode given by the
+	current-precision and current-rounding-mode parameters, as bound on the
+	thread doing the arithmetic.
+*/
+
+const defaultPrecision = 128
+
+var roundingModes = map[Symbol]big.RoundingMode{
+	"to-nearest-even": big.ToNearestEven,
+	"to-nearest-away": big.ToNearestAway,
+	"to-zero":         big.ToZero,
+	"away-from-zero":  big.AwayFromZero,
+	"to-negative-inf": big.ToNegativeInf,
+	"to-positive-inf": big.ToPositiveInf,
+}
+
+var currentPrecision = NewParameter(defaultPrecision, Primitive(func(args interface{}) interface{} {
+	prec, ok := Car(args).(int)
+	if !ok || prec < 1 || uint64(prec) > big.MaxPrec {
+		TypeError("precision", Car(args))
+	}
+	return prec
+}))
+
+var currentRoundingMode = NewParameter(Symbol("to-nearest-even"), Primitive(func(args interface{}) interface{} {
+	mode, ok := Car(args).(Symbol)
+	if _, known := roundingModes[mode]; !ok || !known {
+		TypeError("rounding mode", Car(args))
+	}
+	return mode
+}))
+
+// Without a thread, the default precision and rounding mode are used.
+func newBigfloat(th *Thread) *big.Float {
+	prec := currentPrecision.Get(th).(int)
+	mode := roundingModes[currentRoundingMode.Get(th).(Symbol)]
+	return new(big.Float).SetPrec(uint(prec)).SetMode(mode)
+}
+
+func toBigfloat(th *Thread, x interface{}) *big.Float {
+	switch n := x.(type) {
+	case int:
+		return newBigfloat(th).SetInt64(int64(n))
+	case *big.Int:
+		return newBigfloat(th).SetInt(n)
+	case *big.Rat:
+		return newBigfloat(th).SetRat(n)
+	case float64:
+		if math.IsNaN(n) {
+			Error("NaN cannot be a bigfloat")
+		}
+		return newBigfloat(th).SetFloat64(n)
+	case *big.Float:
+		return n
+	}
+	TypeError("number", x)
+	panic("unreachable")
+}
+
+// Finite bigfloats are converted to exact numbers, infinities to flonums.
+func bigfloatToReal(x *big.Float) interface{} {
+	if x.IsInf() {
+		return toFlonum(x)
+	}
+	r, _ := x.Rat(nil)
+	return normRat(r)
+}
+
+// Operations that have no sensible result, like subtracting infinity from
+// itself, are errors rather than NaN.
+func bigfloatOp(th *Thread, f func(z *big.Float)) (res interface{}) {
+	defer func() {
+		if err := recover(); err != nil {
+			if _, ok := err.(big.ErrNaN); ok {
+				Error("bigfloat operation has no result")
+			}
+			panic(err)
+		}
+	}()
+	z := newBigfloat(th)
+	f(z)
+	return z
+}
+
+// Bigfloats are written with L in place of an exponent marker, so that they
+// read back as bigfloats.
+func formatBigfloat(x *big.Float) string {
+	if x.IsInf() {
+		if x.Signbit() {
+			return "-inf.0"
+		}
+		return "+inf.0"
+	}
+	res := x.Text('g', -1)
+	if i := strings.IndexByte(res, 'e'); i != -1 {
+		return res[:i] + "L" + strings.TrimPrefix(res[i+1:], "+")
+	}
+	return res + "L0"
+}
+
+func parseBigfloat(th *Thread, s string) (*big.Float, bool) {
+	res, _, err := newBigfloat(th).Parse(strings.Replace(strings.ToLower(s), "l", "e", 1), 10)
+	return res, err == nil
+}
+
+// Raises x to an integer power by repeated squaring.
+func bigfloatExpt(th *Thread, x *big.Float, p int) interface{} {
+	return bigfloatOp(th, func(z *big.Float) {
+		neg := p < 0
+		if neg {
+			p = -p
+		}
+		z.SetInt64(1)
+		sq := newBigfloat(th).Set(x)
+		for ; p > 0; p >>= 1 {
+			if p&1 == 1 {
+				z.Mul(z, sq)
+			}
+			sq.Mul(sq, sq)
+		}
+		if neg {
+			z.Quo(newBigfloat(th).SetInt64(1), z)
+		}
+	})
+}
+
+/*
+	Bigfloat primitives
+*/
+
+func bigfloat(th *Thread, x interface{}) interface{} {
+	return bigfloatOp(th, func(z *big.Float) { z.Set(toBigfloat(th, x)) })
+}
+
+func bigfloatPrecision(x interface{}) interface{} {
+	f, ok := x.(*big.Float)
+	if !ok {
+		TypeError("bigfloat", x)
+	}
+	return int(f.Prec())
+}
+
+func bigfloatSqrt(th *Thread, x *big.Float) interface{} {
+	return bigfloatOp(th, func(z *big.Float) { z.Sqrt(x) })
+}
@@ -0,0 +1,30 @@
+package lisp
+
+import "testing"
+
+// Bigfloats are computed at the current precision, and keep it.
+func TestBigfloats(t *testing.T) {
+	checkEval(t, []evalTest{
+		{"1.5L10", "1.5L10"},
+		{"(+ 0.1L0 0.2L0)", "0.3L0"},
+		{"(+ 1 1.5L0)", "2.5L0"},
+		{"(bigfloat-precision 1.5L0)", "128"},
+		{"(/ 1L0 3)", "0.333333333333333333333333333333333333334L0"},
+		{"(with-precision (256) (bigfloat-precision (/ 1L0 3)))", "256"},
+		{"(with-precision (10) (/ 1L0 3))", "0.3335L0"},
+		{"(with-precision (10 'to-zero) (/ 1L0 3))", "0.333L0"},
+		{"(with-precision (-1) 1)", "!type-error"},
+		{"(current-precision)", "128"},
+		{"(= 1.5L0 3/2)", "#t"},
+		{"(round 2.5L0)", "2L0"},
+		{"(sqrt 2L0)", "1.41421356237309504880168872420969807857L0"},
+		{"(expt 2L0 100)", "1.267650600228229401496703205376L30"},
+		{"(flonum? (expt 2L0 0.5))", "#t"},
+		{"(inexact->exact 0.5L0)", "1/2"},
+		{"(with-precision (256) (bigfloat-precision (exact->inexact (/ 1L0 3))))", "256"},
+		{"(/ 1L0 0)", "+inf.0"},
+		{"(- (/ 1L0 0) (/ 1L0 0))", "!error"},
+		{"(bigfloat +nan.0)", "!error"},
+		{`(string->number "2.5L3")`, "2500L0"},
+	})
+}
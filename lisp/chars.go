@@ -0,0 +1,198 @@
+package lisp
+
+import (
+	"fmt"
+	"strconv"
+	"unicode"
+	"unicode/utf8"
+)
+
+/*
+	Characters
+
+	Characters are Unicode code points, represented as runes. They are
+	written #\a, or #\x41 by code point, or by name for those that are hard
+	to see.
+*/
+
+var charNames = map[string]rune{
+	"nul":       0,
+	"null":      0,
+	"alarm":     7,
+	"backspace": 8,
+	"tab":       9,
+	"newline":   10,
+	"linefeed":  10,
+	"return":    13,
+	"escape":    27,
+	"space":     32,
+	"delete":    127,
+}
+
+// The names characters are written with, where there are several.
+var charWriteNames = map[rune]string{
+	0:   "nul",
+	7:   "alarm",
+	8:   "backspace",
+	9:   "tab",
+	10:  "newline",
+	13:  "return",
+	27:  "escape",
+	32:  "space",
+	127: "delete",
+}
+
+// Reads the part of a character literal after #\.
+func parseChar(s string) (rune, bool) {
+	if utf8.RuneCountInString(s) == 1 {
+		r, _ := utf8.DecodeRuneInString(s)
+		return r, true
+	}
+	if r, ok := charNames[s]; ok {
+		return r, true
+	}
+	if len(s) > 1 && s[0] == 'x' {
+		n, err := strconv.ParseUint(s[1:], 16, 32)
+		if err == nil && utf8.ValidRune(rune(n)) {
+			return rune(n), true
+		}
+	}
+	return 0, false
+}
+
+func formatChar(r rune) string {
+	if name, ok := charWriteNames[r]; ok {
+		return "#\\" + name
+	}
+	if unicode.IsPrint(r) {
+		return "#\\" + string(r)
+	}
+	return fmt.Sprintf("#\\x%x", r)
+}
+
+/*
+	Character primitives
+*/
+
+func toChar(x interface{}) rune {
+	r, ok := x.(rune)
+	if !ok {
+		TypeError("char", x)
+	}
+	return r
+}
+
+func charToInt(c interface{}) interface{} {
+	return int(toChar(c))
+}
+
+func intToChar(n interface{}) interface{} {
+	i, ok := n.(int)
+	if !ok {
+		TypeError("fixnum", n)
+	}
+	if i < 0 || i > unicode.MaxRune || !utf8.ValidRune(rune(i)) {
+		Error(fmt.Sprintf("not a code point (%d)", i))
+	}
+	return rune(i)
+}
+
+func charFn(f func(rune) rune) func(c interface{}) interface{} {
+	return func(c interface{}) interface{} {
+		return f(toChar(c))
+	}
+}
+
+func charPred(f func(rune) bool) func(c interface{}) interface{} {
+	return func(c interface{}) interface{} {
+		return f(toChar(c))
+	}
+}
+
+func charFoldcase(r rune) rune {
+	return unicode.ToLower(unicode.ToUpper(r))
+}
+
+// The two letter Unicode general category of c, like Lu or Nd. LC is a
+// grouping of other categories rather than one itself.
+func charGeneralCategory(c interface{}) interface{} {
+	r := toChar(c)
+	for name, table := range unicode.Categories {
+		if len(name) == 2 && name != "LC" && unicode.Is(table, r) {
+			return Symbol(name)
+		}
+	}
+	return Symbol("Cn")
+}
+
+// Decimal digits come in runs of ten, starting with zero.
+func digitValue(c interface{}) interface{} {
+	r := toChar(c)
+	if !unicode.IsDigit(r) {
+		return false
+	}
+	start := r
+	for unicode.IsDigit(start - 1) {
+		start--
+	}
+	return int(r-start) % 10
+}
+
+func charChain(name string, args []interface{}, fold bool, test func(a, b rune) bool) interface{} {
+	if len(args) == 0 {
+		ArgumentError(Symbol(name), EMPTY_LIST)
+	}
+	cs := make([]rune, len(args))
+	for i, x := range args {
+		cs[i] = toChar(x)
+		if fold {
+			cs[i] = charFoldcase(cs[i])
+		}
+	}
+	for i := 1; i < len(cs); i++ {
+		if !test(cs[i-1], cs[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func charEq(args ...interface{}) interface{} {
+	return charChain("char=?", args, false, func(a, b rune) bool { return a == b })
+}
+
+func charLt(args ...interface{}) interface{} {
+	return charChain("char<?", args, false, func(a, b rune) bool { return a < b })
+}
+
+func charGt(args ...interface{}) interface{} {
+	return charChain("char>?", args, false, func(a, b rune) bool { return a > b })
+}
+
+func charLe(args ...interface{}) interface{} {
+	return charChain("char<=?", args, false, func(a, b rune) bool { return a <= b })
+}
+
+func charGe(args ...interface{}) interface{} {
+	return charChain("char>=?", args, false, func(a, b rune) bool { return a >= b })
+}
+
+func charCiEq(args ...interface{}) interface{} {
+	return charChain("char-ci=?", args, true, func(a, b rune) bool { return a == b })
+}
+
+func charCiLt(args ...interface{}) interface{} {
+	return charChain("char-ci<?", args, true, func(a, b rune) bool { return a < b })
+}
+
+func charCiGt(args ...interface{}) interface{} {
+	return charChain("char-ci>?", args, true, func(a, b rune) bool { return a > b })
+}
+
+func charCiLe(args ...interface{}) interface{} {
+	return charChain("char-ci<=?", args, true, func(a, b rune) bool { return a <= b })
+}
+
+func charCiGe(args ...interface{}) interface{} {
+	return charChain("char-ci>=?", args, true, func(a, b rune) bool { return a >= b })
+}
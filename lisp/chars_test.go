@@ -0,0 +1,27 @@
+package lisp
+
+import "testing"
+
+// Chars are read and written with #\ syntax, by name where they have one.
+func TestChars(t *testing.T) {
+	checkEval(t, []evalTest{
+		{`#\space`, `#\space`},
+		{`#\x41`, `#\A`},
+		{`#\x`, `#\x`},
+		{`#\(`, `#\(`},
+		{`#\λ`, `#\λ`},
+		{`'(#\a #\b)`, `(#\a #\b)`},
+		{`(integer->char 0)`, `#\nul`},
+		{`(integer->char 1)`, `#\x1`},
+		{`(integer->char 55296)`, "!error"},
+		{`(char->integer #\A)`, "65"},
+		{`(char-upcase #\a)`, `#\A`},
+		{`(char-general-category #\a)`, "Ll"},
+		{`(digit-value #\a)`, "#f"},
+		{`(char<? #\a #\c #\b)`, "#f"},
+		{`(char-ci=? #\a #\A)`, "#t"},
+		{`(char=? #\a 1)`, "!type-error"},
+		{`(object->string #\a)`, `"a"`},
+		{`(string->list "ab")`, `(#\a #\b)`},
+	})
+}
@@ -0,0 +1,177 @@
+package lisp
+
+import (
+	"math"
+	"math/cmplx"
+	"strconv"
+	"strings"
+)
+
+/*
+	Complex numbers
+
+	Complex numbers are always inexact. Results with no imaginary part are
+	given as flonums.
+*/
+
+func normComplex(c complex128) interface{} {
+	if imag(c) == 0 {
+		return real(c)
+	}
+	return c
+}
+
+func toComplex(x interface{}) complex128 {
+	if c, ok := x.(complex128); ok {
+		return c
+	}
+	return complex(toFlonum(x), 0)
+}
+
+// Whether x is a negative real number, which has no real square root or
+// logarithm.
+func isNegative(x interface{}) bool {
+	if _, ok := x.(complex128); ok {
+		return false
+	}
+	c, ok := numCompare(x, 0)
+	return ok && c < 0
+}
+
+func formatComplexPart(x float64) string {
+	switch {
+	case math.IsNaN(x):
+		return "+nan.0"
+	case math.IsInf(x, 1):
+		return "+inf.0"
+	case math.IsInf(x, -1):
+		return "-inf.0"
+	}
+	return strconv.FormatFloat(x, 'g', -1, 64)
+}
+
+// Written as a+bi, leaving out the real part if it is zero and the
+// magnitude of the imaginary part if it is one.
+func formatComplex(c complex128) string {
+	res := ""
+	if real(c) != 0 {
+		res = formatComplexPart(real(c))
+	}
+	switch im := imag(c); {
+	case im == 1:
+		res += "+"
+	case im == -1:
+		res += "-"
+	default:
+		part := formatComplexPart(im)
+		if part[0] != '+' && part[0] != '-' {
+			part = "+" + part
+		}
+		res += part
+	}
+	return res + "i"
+}
+
+// Parts may be infinite or not a number, written as they are printed.
+func parseComplexReal(s string) (float64, bool) {
+	switch s {
+	case "+inf.0":
+		return math.Inf(1), true
+	case "-inf.0":
+		return math.Inf(-1), true
+	case "+nan.0", "-nan.0":
+		return math.NaN(), true
+	}
+	res, err := strconv.ParseFloat(s, 64)
+	return res, err == nil
+}
+
+func parseComplexPart(s string) (float64, bool) {
+	switch s {
+	case "+":
+		return 1, true
+	case "-":
+		return -1, true
+	}
+	return parseComplexReal(s)
+}
+
+// Reads a+bi, with the real part and the magnitude of the imaginary part
+// optional, or m@a in polar form.
+func parseComplex(s string) (complex128, bool) {
+	if i := strings.IndexByte(s, '@'); i != -1 {
+		m, ok1 := parseComplexReal(s[:i])
+		a, ok2 := parseComplexReal(s[i+1:])
+		return cmplx.Rect(m, a), ok1 && ok2
+	}
+	if !strings.HasSuffix(s, "i") {
+		return 0, false
+	}
+	s = s[:len(s)-1]
+	// the imaginary part starts with the last sign not in an exponent
+	split := strings.LastIndexAny(s, "+-")
+	for split > 0 && strings.ContainsAny(s[split-1:split], "eE") {
+		split = strings.LastIndexAny(s[:split], "+-")
+	}
+	if split == -1 {
+		return 0, false
+	}
+	re := 0.0
+	if split > 0 {
+		var ok bool
+		if re, ok = parseComplexReal(s[:split]); !ok {
+			return 0, false
+		}
+	}
+	im, ok := parseComplexPart(s[split:])
+	return complex(re, im), ok
+}
+
+/*
+	Complex primitives
+*/
+
+func makeRectangular(x, y interface{}) interface{} {
+	if y == 0 {
+		numLevel(x)
+		return x
+	}
+	return normComplex(complex(toFlonum(x), toFlonum(y)))
+}
+
+func makePolar(m, a interface{}) interface{} {
+	return normComplex(cmplx.Rect(toFlonum(m), toFlonum(a)))
+}
+
+func realPart(z interface{}) interface{} {
+	if c, ok := z.(complex128); ok {
+		return real(c)
+	}
+	numLevel(z)
+	return z
+}
+
+func imagPart(z interface{}) interface{} {
+	if c, ok := z.(complex128); ok {
+		return imag(c)
+	}
+	numLevel(z)
+	return 0
+}
+
+func magnitude(z interface{}) interface{} {
+	if c, ok := z.(complex128); ok {
+		return cmplx.Abs(c)
+	}
+	return abs(z)
+}
+
+func angle(z interface{}) interface{} {
+	if c, ok := z.(complex128); ok {
+		return cmplx.Phase(c)
+	}
+	if numLevel(z) < flonumLevel && !isNegative(z) {
+		return 0
+	}
+	return math.Atan2(0, toFlonum(z))
+}
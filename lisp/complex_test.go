@@ -0,0 +1,29 @@
+package lisp
+
+import "testing"
+
+// Complex numbers are written as they are read, infinite parts included.
+func TestComplexNumbers(t *testing.T) {
+	checkEval(t, []evalTest{
+		{"(sqrt -4)", "+2i"},
+		{"(* 1+2i 3-i)", "5+5i"},
+		{"(+ 1+2i 1-2i)", "2.0"},
+		{"(/ 1+i 2)", "0.5+0.5i"},
+		{"1e3+1e-3i", "1000+0.001i"},
+		{"(make-rectangular 1 0)", "1"},
+		{"(magnitude 3+4i)", "5.0"},
+		{"(log -1)", "+3.141592653589793i"},
+		{"(expt -8 1/3)", "1+1.732050807568877i"},
+		{"(< 1+2i 2)", "!type-error"},
+		{"(real? 1+i)", "#f"},
+		{"(number->string 1-i)", `"1-i"`},
+		{`(string->number "+i")`, "+i"},
+		{"(symbol? '1+)", "#t"},
+		{"(make-rectangular 1 (/ -1.0 0))", "1-inf.0i"},
+		{"1+inf.0i", "1+inf.0i"},
+		{"+inf.0-nan.0i", "+inf.0+nan.0i"},
+		{"(real-part -inf.0+2i)", "-inf.0"},
+		{`(string->number "-inf.0-inf.0i")`, "-inf.0-inf.0i"},
+		{`(string->number "+i.0i")`, "#f"},
+	})
+}
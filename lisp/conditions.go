@@ -0,0 +1,439 @@
+package lisp
+
+import (
+	"fmt"
+	"sync"
+)
+
+/*
+	Condition types
+
+	Each kind of condition has a parent kind. Kinds that have not been
+	registered are taken to be plain conditions.
+*/
+
+var conditionTypes = struct {
+	sync.RWMutex
+	parents map[Symbol]Symbol
+}{parents: map[Symbol]Symbol{
+	"error":          "condition",
+	"warning":        "condition",
+	"cancelled":      "condition",
+	"non-condition":  "condition",
+	"type-error":     "error",
+	"argument-error": "error",
+	"syntax-error":   "error",
+	"system-error":   "error",
+}}
+
+func DefineConditionType(kind, parent Symbol) {
+	if kind == "condition" {
+		Error("cannot redefine condition")
+	}
+	conditionTypes.Lock()
+	defer conditionTypes.Unlock()
+	for p := parent; p != "condition"; {
+		if p == kind {
+			Error(fmt.Sprintf("circular condition type: %s", kind))
+		}
+		next, ok := conditionTypes.parents[p]
+		if !ok {
+			break
+		}
+		p = next
+	}
+	conditionTypes.parents[kind] = parent
+}
+
+// Whether conditions of kind are also conditions of kind ancestor.
+func ConditionIs(kind, ancestor Symbol) bool {
+	conditionTypes.RLock()
+	defer conditionTypes.RUnlock()
+	for {
+		if kind == ancestor || ancestor == "condition" {
+			return true
+		}
+		parent, ok := conditionTypes.parents[kind]
+		if !ok {
+			return false
+		}
+		kind = parent
+	}
+}
+
+/*
+	Handlers
+
+	Raising a condition calls the innermost handler installed by
+	with-exception-handler, in the dynamic context of the raise. Forms that
+	catch conditions, like guard and catch, install a frame with no handler:
+	when the innermost frame is one of these the condition unwinds the stack
+	to it. Handlers belong to the thread that installed them.
+
+	Go code has no thread to hand, so conditions it throws unwind to the
+	innermost frame that installed a handler, restart or catch, and are
+	raised there.
+*/
+
+type handlerFrame struct {
+	h    Function
+	next *handlerFrame
+}
+
+// What handlers see of a raised value.
+func conditionValue(c *errorStruct) interface{} {
+	if c.raw {
+		return c.msg
+	}
+	return c
+}
+
+// Raises c. If the raise is continuable and the handler returns, so does
+// Raise, with the handler's result.
+func Raise(th *Thread, c *errorStruct, continuable bool) interface{} {
+	if th.handlers == nil || th.handlers.h == nil {
+		panic(c)
+	}
+	frame := th.handlers
+	th.handlers = frame.next
+	defer func() { th.handlers = frame }()
+	res := callOn(th, frame.h, conditionValue(c))
+	if !continuable {
+		Raise(th, NewCondition(
+			Symbol("error"),
+			"handler returned from non-continuable raise",
+			List(Cons(Symbol("irritants"), List(conditionValue(c)))),
+		), false)
+	}
+	return res
+}
+
+// Raises err on the thread if it was thrown by Go code and has not been
+// raised yet.
+func (self *Thread) signal(err interface{}) {
+	if c, ok := err.(*errorStruct); ok && c.thrown {
+		c.thrown = false
+		Raise(self, c, false)
+	}
+}
+
+// Calls f with a frame that stops conditions from reaching handlers outside
+// it. Returns what was raised, if anything.
+func catchErrors(th *Thread, f func()) (err *errorStruct, failed bool) {
+	saved := th.handlers
+	th.handlers = &handlerFrame{nil, saved}
+	defer func() {
+		th.handlers = saved
+		if x := recover(); x != nil {
+			if _, ok := x.(escape); ok {
+				panic(x)
+			}
+			if _, ok := x.(error); !ok {
+				panic(x)
+			}
+			err, failed = WrapError(x).(*errorStruct), true
+			err.thrown = false
+		}
+	}()
+	f()
+	return
+}
+
+func WithExceptionHandler(th *Thread, h, thk Function) interface{} {
+	saved := th.handlers
+	th.handlers = &handlerFrame{h, saved}
+	defer func() {
+		if err := recover(); err != nil {
+			th.signal(err)
+			th.handlers = saved
+			panic(err)
+		}
+		th.handlers = saved
+	}()
+	return callOn(th, thk)
+}
+
+/*
+	Restarts
+
+	A restart is a way of recovering from a condition, established by
+	restart-case around some code. A handler can invoke one to unwind the
+	stack to where the restart was established and continue from there.
+*/
+
+type Restart struct {
+	name  Symbol
+	f     Function
+	point *int
+	next  *Restart
+}
+
+func (self *Restart) String() string {
+	return self.GoString()
+}
+
+func (self *Restart) GoString() string {
+	return fmt.Sprintf("#<restart %s>", self.name)
+}
+
+type restartInvocation struct {
+	r    *Restart
+	args interface{}
+}
+
+func (*restartInvocation) escape() {}
+
+// Calls thk with the restarts available. If one of them is invoked its
+// function is called with the arguments given, and the result returned.
+func WithRestarts(th *Thread, names []Symbol, fs []Function, thk Function) interface{} {
+	point := new(int)
+	saved := th.restarts
+	for i := len(names) - 1; i >= 0; i-- {
+		th.restarts = &Restart{names[i], fs[i], point, th.restarts}
+	}
+	var (
+		res     interface{}
+		invoked *restartInvocation
+	)
+	func() {
+		defer func() {
+			th.restarts = saved
+			if err := recover(); err != nil {
+				if inv, ok := err.(*restartInvocation); ok && inv.r.point == point {
+					invoked = inv
+					return
+				}
+				panic(err)
+			}
+		}()
+		// handlers for conditions thrown by Go code may invoke these
+		// restarts, so are called before they are taken away
+		func() {
+			defer func() {
+				if err := recover(); err != nil {
+					th.signal(err)
+					panic(err)
+				}
+			}()
+			res = callOn(th, thk)
+		}()
+	}()
+	if invoked != nil {
+		return applyOn(th, invoked.r.f, invoked.args)
+	}
+	return res
+}
+
+func findRestart(th *Thread, name Symbol) *Restart {
+	for r := th.restarts; r != nil; r = r.next {
+		if r.name == name {
+			return r
+		}
+	}
+	return nil
+}
+
+func InvokeRestart(r *Restart, args interface{}) {
+	panic(&restartInvocation{r, args})
+}
+
+/*
+	Condition primitives
+*/
+
+func toCondition(x interface{}) *errorStruct {
+	c, ok := x.(*errorStruct)
+	if !ok || c.raw {
+		TypeError("condition", x)
+	}
+	return c
+}
+
+func makeCondition(kind, msg, fields interface{}) interface{} {
+	k, ok := kind.(Symbol)
+	if !ok {
+		TypeError("symbol", kind)
+	}
+	var alist interface{} = EMPTY_LIST
+	for cur := fields; cur != EMPTY_LIST; cur = Cdr(Cdr(cur)) {
+		name, ok := Car(cur).(Symbol)
+		if !ok {
+			TypeError("symbol", Car(cur))
+		}
+		if Cdr(cur) == EMPTY_LIST {
+			Error(fmt.Sprintf("no value for condition field %s", name))
+		}
+		alist = Cons(Cons(name, Car(Cdr(cur))), alist)
+	}
+	return NewCondition(k, msg, vecToLs(reverseVec(lsToVec(alist).(Vector))))
+}
+
+func reverseVec(v Vector) Vector {
+	for i, j := 0, len(v)-1; i < j; i, j = i+1, j-1 {
+		v[i], v[j] = v[j], v[i]
+	}
+	return v
+}
+
+func conditionKind(c interface{}) interface{} {
+	return toCondition(c).kind
+}
+
+func conditionMessage(c interface{}) interface{} {
+	return toCondition(c).msg
+}
+
+func conditionFields(c interface{}) interface{} {
+	return toCondition(c).fields
+}
+
+func conditionField(c, name, dflt interface{}) interface{} {
+	n, ok := name.(Symbol)
+	if !ok {
+		TypeError("symbol", name)
+	}
+	if v, ok := toCondition(c).Field(n); ok {
+		return v
+	}
+	return dflt
+}
+
+func conditionIs(c, kind interface{}) interface{} {
+	k, ok := kind.(Symbol)
+	if !ok {
+		TypeError("symbol", kind)
+	}
+	return ConditionIs(toCondition(c).kind, k)
+}
+
+func registerConditionType(kind, parent interface{}) interface{} {
+	k, ok := kind.(Symbol)
+	if !ok {
+		TypeError("symbol", kind)
+	}
+	p, ok := parent.(Symbol)
+	if !ok {
+		TypeError("symbol", parent)
+	}
+	DefineConditionType(k, p)
+	return nil
+}
+
+func toRaise(obj interface{}) *errorStruct {
+	if c, ok := obj.(*errorStruct); ok && !c.raw {
+		return c
+	}
+	return &errorStruct{kind: "non-condition", msg: obj, fields: EMPTY_LIST, raw: true}
+}
+
+func raise(th *Thread, obj interface{}) interface{} {
+	Raise(th, toRaise(obj), false)
+	panic("unreachable")
+}
+
+func raiseContinuable(th *Thread, obj interface{}) interface{} {
+	return Raise(th, toRaise(obj), true)
+}
+
+func withExceptionHandler(th *Thread, hnd, thk interface{}) interface{} {
+	h, ok := hnd.(Function)
+	if !ok {
+		TypeError("function", hnd)
+	}
+	t, ok := thk.(Function)
+	if !ok {
+		TypeError("function", thk)
+	}
+	return WithExceptionHandler(th, h, t)
+}
+
+// The handler is called with what was raised and a function that raises it
+// again.
+func guardCall(th *Thread, thk, hnd interface{}) interface{} {
+	t, ok := thk.(Function)
+	if !ok {
+		TypeError("function", thk)
+	}
+	h, ok := hnd.(Function)
+	if !ok {
+		TypeError("function", hnd)
+	}
+	var res interface{}
+	err, failed := catchErrors(th, func() { res = callOn(th, t) })
+	if !failed {
+		return res
+	}
+	reraise := Primitive(func(_ interface{}) interface{} {
+		panic(err)
+	})
+	return callOn(th, h, conditionValue(err), reraise)
+}
+
+func callWithRestarts(th *Thread, names, fns, thk interface{}) interface{} {
+	t, ok := thk.(Function)
+	if !ok {
+		TypeError("function", thk)
+	}
+	ns := lsToVec(names).(Vector)
+	fs := lsToVec(fns).(Vector)
+	if len(ns) != len(fs) {
+		Error("restart names and functions differ in number")
+	}
+	rn := make([]Symbol, len(ns))
+	rf := make([]Function, len(fs))
+	for i := range ns {
+		n, ok := ns[i].(Symbol)
+		if !ok {
+			TypeError("symbol", ns[i])
+		}
+		f, ok := fs[i].(Function)
+		if !ok {
+			TypeError("function", fs[i])
+		}
+		rn[i], rf[i] = n, f
+	}
+	return WithRestarts(th, rn, rf, t)
+}
+
+func findRestartPrim(th *Thread, name interface{}) interface{} {
+	n, ok := name.(Symbol)
+	if !ok {
+		TypeError("symbol", name)
+	}
+	if r := findRestart(th, n); r != nil {
+		return r
+	}
+	return false
+}
+
+func computeRestarts(th *Thread) interface{} {
+	var res Vector
+	for r := th.restarts; r != nil; r = r.next {
+		res = append(res, r)
+	}
+	return vecToLs(res)
+}
+
+func restartName(restart interface{}) interface{} {
+	r, ok := restart.(*Restart)
+	if !ok {
+		TypeError("restart", restart)
+	}
+	return r.name
+}
+
+func invokeRestart(th *Thread, restart, args interface{}) interface{} {
+	switch r := restart.(type) {
+	case *Restart:
+		InvokeRestart(r, args)
+	case Symbol:
+		found := findRestart(th, r)
+		if found == nil {
+			Error(fmt.Sprintf("no restart named %s", r))
+		}
+		InvokeRestart(found, args)
+	default:
+		TypeError("restart", restart)
+	}
+	panic("unreachable")
+}
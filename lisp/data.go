@@ -2,9 +2,14 @@ package lisp
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
 	"unsafe"
 )
 
@@ -52,10 +57,28 @@ type Function interface {
 	Apply(args interface{}) interface{}
 }
 
+// Functions that run on behalf of the thread calling them, and so can see
+// its dynamic state.
+type threadFunction interface {
+	applyOn(th *Thread, args interface{}) interface{}
+}
+
 func Call(f Function, args ...interface{}) interface{} {
 	return f.Apply(vecToLs(Vector(args)))
 }
 
+// Applies f on behalf of th.
+func applyOn(th *Thread, f Function, args interface{}) interface{} {
+	if tf, ok := f.(threadFunction); ok {
+		return tf.applyOn(th, args)
+	}
+	return f.Apply(args)
+}
+
+func callOn(th *Thread, f Function, args ...interface{}) interface{} {
+	return applyOn(th, f, vecToLs(Vector(args)))
+}
+
 type Primitive func(args interface{}) interface{}
 
 func (self Primitive) Apply(args interface{}) interface{} {
@@ -70,10 +93,31 @@ func (self Primitive) GoString() string {
 	return "#<primitive>"
 }
 
+// Primitives that need the thread calling them. Called from Go, which has no
+// thread to hand, they run on one of their own.
+type threadPrimitive func(th *Thread, args interface{}) interface{}
+
+func (self threadPrimitive) Apply(args interface{}) interface{} {
+	return self(mainThread(), args)
+}
+
+func (self threadPrimitive) applyOn(th *Thread, args interface{}) interface{} {
+	return self(th, args)
+}
+
+func (self threadPrimitive) String() string {
+	return self.GoString()
+}
+
+func (self threadPrimitive) GoString() string {
+	return "#<primitive>"
+}
+
 // Takes a function, which can take interface{}thing from none to five lisp.interface{} and
 // must return lisp.interface{}, and returns a function that can be called by the
-// lisp system. Crashes if it fails to match, which I suppose is pretty
-// bad, really.
+// lisp system. Functions that need the calling thread may also take it as
+// their first argument. Crashes if it fails to match, which I suppose is
+// pretty bad, really.
 func WrapPrimitive(_f interface{}) Function {
 	wrap := func(l int, f func(Vector) interface{}) Function {
 		var res Function
@@ -86,6 +130,17 @@ func WrapPrimitive(_f interface{}) Function {
 		})
 		return res
 	}
+	wrapOn := func(l int, f func(*Thread, Vector) interface{}) Function {
+		var res Function
+		res = threadPrimitive(func(th *Thread, args interface{}) interface{} {
+			as := lsToVec(args).(Vector)
+			if len(as) != l {
+				ArgumentError(res, args)
+			}
+			return f(th, as)
+		})
+		return res
+	}
 	switch f := _f.(type) {
 	case func() interface{}:
 		return wrap(0, func(args Vector) interface{} {
@@ -111,6 +166,32 @@ func WrapPrimitive(_f interface{}) Function {
 		return wrap(5, func(args Vector) interface{} {
 			return f(args[0], args[1], args[2], args[3], args[4])
 		})
+	case func(args ...interface{}) interface{}:
+		return Primitive(func(args interface{}) interface{} {
+			return f(lsToVec(args).(Vector)...)
+		})
+	case func(th *Thread) interface{}:
+		return wrapOn(0, func(th *Thread, args Vector) interface{} {
+			return f(th)
+		})
+	case func(th *Thread, a interface{}) interface{}:
+		return wrapOn(1, func(th *Thread, args Vector) interface{} {
+			return f(th, args[0])
+		})
+	case func(th *Thread, a, b interface{}) interface{}:
+		return wrapOn(2, func(th *Thread, args Vector) interface{} {
+			return f(th, args[0], args[1])
+		})
+	case func(th *Thread, a, b, c interface{}) interface{}:
+		return wrapOn(3, func(th *Thread, args Vector) interface{} {
+			return f(th, args[0], args[1], args[2])
+		})
+	case func(th *Thread, args ...interface{}) interface{}:
+		return threadPrimitive(func(th *Thread, args interface{}) interface{} {
+			return f(th, lsToVec(args).(Vector)...)
+		})
+	case Function:
+		return f
 	}
 	Error(fmt.Sprintf("invalid primitive function: %s", toWrite("%#v", _f)))
 	return nil
@@ -130,9 +211,39 @@ func WrapPrimitives(env map[string]interface{}) Environment {
 	Errors
 */
 
+// Errors are conditions: a kind, which places them in the hierarchy of
+// condition types, a message and an association list of further fields.
+// Objects that are not conditions may also be raised, in which case they
+// are carried in msg. Conditions thrown by Go code are marked as such until
+// they have been raised on the thread that threw them.
 type errorStruct struct {
-	kind Symbol
-	msg  interface{}
+	kind   Symbol
+	msg    interface{}
+	fields interface{}
+	raw    bool
+	thrown bool
+}
+
+func NewCondition(kind Symbol, msg, fields interface{}) *errorStruct {
+	return &errorStruct{kind: kind, msg: msg, fields: fields}
+}
+
+func (self *errorStruct) Kind() Symbol {
+	return self.kind
+}
+
+func (self *errorStruct) Message() interface{} {
+	return self.msg
+}
+
+func (self *errorStruct) Field(name Symbol) (interface{}, bool) {
+	for cur := self.fields; cur != EMPTY_LIST; cur = Cdr(cur) {
+		f := Car(cur).(*Pair)
+		if f.a == name {
+			return f.d, true
+		}
+	}
+	return nil, false
 }
 
 func (self *errorStruct) Error() string {
@@ -144,7 +255,19 @@ func (self *errorStruct) String() string {
 }
 
 func (self *errorStruct) GoString() string {
-	return fmt.Sprintf("%v: %s", self.kind, toWrite("%v", self.msg))
+	res := fmt.Sprintf("%v: %s", self.kind, toWrite("%v", self.msg))
+	if irritants, ok := self.Field(Symbol("irritants")); ok {
+		for cur := irritants; cur != EMPTY_LIST; cur = Cdr(cur) {
+			res += " " + toWrite("%#v", Car(cur))
+		}
+	}
+	return res
+}
+
+// Panics that transfer control rather than report an error, such as a
+// transaction being retried. Handlers let these pass through.
+type escape interface {
+	escape()
 }
 
 func Failed(x interface{}) bool {
@@ -157,15 +280,17 @@ func WrapError(err interface{}) error {
 	case *errorStruct:
 		return e
 	case error:
-		return &errorStruct{Symbol("system-error"), e.Error()}
+		return NewCondition(Symbol("system-error"), e.Error(), EMPTY_LIST)
 	default:
 		TypeError("error", err)
 	}
 	panic("unreachable")
 }
 
+// Go code has no thread to hand, so the condition is raised once it has
+// unwound to a frame that installed a handler, restart or catch.
 func Throw(kind Symbol, msg interface{}) {
-	panic(&errorStruct{kind, msg})
+	panic(&errorStruct{kind: kind, msg: msg, fields: EMPTY_LIST, thrown: true})
 }
 
 func Error(msg string) {
@@ -312,20 +437,61 @@ var (
 	_PORT_CLOSED = errors.New("port closed")
 )
 
+// Ports may be shared between threads. Each operation on a port holds its
+// lock, which may also be held across several operations by one thread.
+// Operations done on no thread, as from Go, never hold it already.
+type portLock struct {
+	m     sync.Mutex
+	owner int64
+	depth int
+}
+
+func (self *portLock) Lock(th *Thread) {
+	var id int64
+	if th != nil {
+		id = th.id
+	}
+	if id != 0 && atomic.LoadInt64(&self.owner) == id {
+		self.depth++
+		return
+	}
+	self.m.Lock()
+	atomic.StoreInt64(&self.owner, id)
+	self.depth = 1
+}
+
+func (self *portLock) Unlock() {
+	self.depth--
+	if self.depth == 0 {
+		atomic.StoreInt64(&self.owner, 0)
+		self.m.Unlock()
+	}
+}
+
 type InputPort struct {
-	eof bool
-	ref io.Reader
-	r   *bufio.Reader
+	lock portLock
+	eof  bool
+	ref  io.Reader
+	r    *bufio.Reader
 }
 
 func NewInput(r io.Reader) *InputPort {
 	if p, ok := r.(*InputPort); ok {
 		return p
 	}
-	return &InputPort{false, r, bufio.NewReader(r)}
+	return &InputPort{ref: r, r: bufio.NewReader(r)}
 }
 
 func (self *InputPort) Read(bs []byte) (int, error) {
+	if isThreaded() {
+		self.lock.Lock(nil)
+		defer self.lock.Unlock()
+	}
+	return self.read(bs)
+}
+
+// Reads without taking the lock, for callers that already hold it.
+func (self *InputPort) read(bs []byte) (int, error) {
 	if self.r == nil {
 		return 0, _PORT_CLOSED
 	}
@@ -337,7 +503,23 @@ func (self *InputPort) Read(bs []byte) (int, error) {
 	return l, err
 }
 
+type unlockedInput struct {
+	p *InputPort
+}
+
+func (self unlockedInput) Read(bs []byte) (int, error) {
+	return self.p.read(bs)
+}
+
 func (self *InputPort) ReadChar() interface{} {
+	return self.readCharOn(nil)
+}
+
+func (self *InputPort) readCharOn(th *Thread) interface{} {
+	if isThreaded() {
+		self.lock.Lock(th)
+		defer self.lock.Unlock()
+	}
 	if self.r == nil {
 		SystemError(_PORT_CLOSED)
 	}
@@ -355,6 +537,14 @@ func (self *InputPort) ReadChar() interface{} {
 }
 
 func (self *InputPort) ReadByte() interface{} {
+	return self.readByteOn(nil)
+}
+
+func (self *InputPort) readByteOn(th *Thread) interface{} {
+	if isThreaded() {
+		self.lock.Lock(th)
+		defer self.lock.Unlock()
+	}
 	if self.r == nil {
 		SystemError(_PORT_CLOSED)
 	}
@@ -362,7 +552,7 @@ func (self *InputPort) ReadByte() interface{} {
 		return EOF_OBJECT
 	}
 	bs := []byte{0}
-	_, err := self.Read(bs)
+	_, err := self.read(bs)
 	if err != nil {
 		self.eof = err == io.EOF
 		if !self.eof {
@@ -373,6 +563,14 @@ func (self *InputPort) ReadByte() interface{} {
 }
 
 func (self *InputPort) ReadLine() interface{} {
+	return self.readLineOn(nil)
+}
+
+func (self *InputPort) readLineOn(th *Thread) interface{} {
+	if isThreaded() {
+		self.lock.Lock(th)
+		defer self.lock.Unlock()
+	}
 	if self.r == nil {
 		SystemError(_PORT_CLOSED)
 	}
@@ -398,6 +596,14 @@ func (self *InputPort) ReadLine() interface{} {
 }
 
 func (self *InputPort) Close() {
+	self.closeOn(nil)
+}
+
+func (self *InputPort) closeOn(th *Thread) {
+	if isThreaded() {
+		self.lock.Lock(th)
+		defer self.lock.Unlock()
+	}
 	if self.r == nil {
 		SystemError(_PORT_CLOSED)
 	}
@@ -410,23 +616,50 @@ func (self *InputPort) Close() {
 	}
 }
 
+// Holds the port's lock, so that a thread may perform several operations
+// without others interleaving.
+func (self *InputPort) Lock() {
+	self.lockOn(nil)
+}
+
+func (self *InputPort) lockOn(th *Thread) {
+	self.lock.Lock(th)
+}
+
+func (self *InputPort) Unlock() {
+	self.lock.Unlock()
+}
+
 func (self *InputPort) Eof() bool {
+	return self.eofOn(nil)
+}
+
+func (self *InputPort) eofOn(th *Thread) bool {
+	if isThreaded() {
+		self.lock.Lock(th)
+		defer self.lock.Unlock()
+	}
 	return self.eof
 }
 
 type OutputPort struct {
-	ref io.Writer
-	w   *bufio.Writer
+	lock portLock
+	ref  io.Writer
+	w    *bufio.Writer
 }
 
 func NewOutput(w io.Writer) *OutputPort {
 	if p, ok := w.(*OutputPort); ok {
 		return p
 	}
-	return &OutputPort{w, bufio.NewWriter(w)}
+	return &OutputPort{ref: w, w: bufio.NewWriter(w)}
 }
 
 func (self *OutputPort) Write(bs []byte) (int, error) {
+	if isThreaded() {
+		self.lock.Lock(nil)
+		defer self.lock.Unlock()
+	}
 	if self.w == nil {
 		return 0, _PORT_CLOSED
 	}
@@ -434,6 +667,14 @@ func (self *OutputPort) Write(bs []byte) (int, error) {
 }
 
 func (self *OutputPort) WriteString(str string) {
+	self.writeStringOn(nil, str)
+}
+
+func (self *OutputPort) writeStringOn(th *Thread, str string) {
+	if isThreaded() {
+		self.lock.Lock(th)
+		defer self.lock.Unlock()
+	}
 	if self.w == nil {
 		SystemError(_PORT_CLOSED)
 	}
@@ -444,14 +685,32 @@ func (self *OutputPort) WriteString(str string) {
 }
 
 func (self *OutputPort) WriteByte(b byte) {
-	bs := []byte{b}
-	_, err := self.Write(bs)
+	self.writeByteOn(nil, b)
+}
+
+func (self *OutputPort) writeByteOn(th *Thread, b byte) {
+	if isThreaded() {
+		self.lock.Lock(th)
+		defer self.lock.Unlock()
+	}
+	if self.w == nil {
+		SystemError(_PORT_CLOSED)
+	}
+	err := self.w.WriteByte(b)
 	if err != nil {
 		SystemError(err)
 	}
 }
 
 func (self *OutputPort) Flush() {
+	self.flushOn(nil)
+}
+
+func (self *OutputPort) flushOn(th *Thread) {
+	if isThreaded() {
+		self.lock.Lock(th)
+		defer self.lock.Unlock()
+	}
 	if self.w == nil {
 		SystemError(_PORT_CLOSED)
 	}
@@ -461,7 +720,27 @@ func (self *OutputPort) Flush() {
 	}
 }
 
+func (self *OutputPort) Lock() {
+	self.lockOn(nil)
+}
+
+func (self *OutputPort) lockOn(th *Thread) {
+	self.lock.Lock(th)
+}
+
+func (self *OutputPort) Unlock() {
+	self.lock.Unlock()
+}
+
 func (self *OutputPort) Close() {
+	self.closeOn(nil)
+}
+
+func (self *OutputPort) closeOn(th *Thread) {
+	if isThreaded() {
+		self.lock.Lock(th)
+		defer self.lock.Unlock()
+	}
 	if self.w == nil {
 		SystemError(_PORT_CLOSED)
 	}
@@ -474,6 +753,558 @@ func (self *OutputPort) Close() {
 	}
 }
 
+/*
+	Threads
+*/
+
+// Where errors that escape a thread are reported.
+var ThreadErrors io.Writer = os.Stderr
+
+var threadCount int32
+
+// Threads are also numbered for ownership of port locks, including those
+// that are never started.
+var threadIds int64
+
+// A thread also carries the dynamic state of the code running on it: the
+// context that cancels it, parameter bindings, handlers, restarts and the
+// current transaction. Only the thread itself touches these.
+type Thread struct {
+	id       int64
+	name     string
+	ctx      context.Context
+	params   *paramFrame
+	handlers *handlerFrame
+	restarts *Restart
+	group    *taskGroup
+	actor    *Actor
+	tx       *transaction
+	quiet    bool
+	done     chan struct{}
+	res      interface{}
+	err      interface{}
+	failed   bool
+}
+
+// Runs f on a new goroutine and returns a handle to it.
+func NewThread(f Function, args interface{}) *Thread {
+	res := newThread(context.Background(), nil)
+	res.start(f, args)
+	return res
+}
+
+func newThread(ctx context.Context, params *paramFrame) *Thread {
+	n := atomic.AddInt32(&threadCount, 1)
+	return &Thread{
+		id:     atomic.AddInt64(&threadIds, 1),
+		name:   fmt.Sprintf("thread-%d", n),
+		ctx:    ctx,
+		params: params,
+		done:   make(chan struct{}),
+	}
+}
+
+func (self *Thread) start(f Function, args interface{}) {
+	enterThreaded()
+	go self.run(f, args)
+}
+
+// A thread for Go code calling into lisp. It is never started. Each
+// interpreter keeps one for the code it is given to evaluate.
+func mainThread() *Thread {
+	return &Thread{
+		id:   atomic.AddInt64(&threadIds, 1),
+		name: "main",
+		ctx:  context.Background(),
+	}
+}
+
+// Threads started by code running on this one share its cancellation and
+// start with its parameter bindings.
+func (self *Thread) spawn(f Function, args interface{}) *Thread {
+	res := newThread(self.ctx, self.params)
+	res.start(f, args)
+	return res
+}
+
+func (self *Thread) run(f Function, args interface{}) {
+	defer close(self.done)
+	if self.group != nil {
+		defer self.group.wait.Done()
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			self.err, self.failed = err, true
+			if self.group != nil {
+				self.group.fail(err)
+			} else if !self.quiet {
+				fmt.Fprintf(ThreadErrors, "%s: %s\n", self.name, toWrite("%v", err))
+			}
+		}
+	}()
+	self.res = applyOn(self, f, args)
+}
+
+func (self *Thread) Name() string {
+	return self.name
+}
+
+// Waits for the thread to finish, returning its result. If the thread failed
+// its error is raised again here.
+func (self *Thread) Join() interface{} {
+	<-self.done
+	if self.failed {
+		panic(self.err)
+	}
+	return self.res
+}
+
+func (self *Thread) Done() bool {
+	select {
+	case <-self.done:
+		return true
+	default:
+	}
+	return false
+}
+
+func (self *Thread) String() string {
+	return self.GoString()
+}
+
+func (self *Thread) GoString() string {
+	return fmt.Sprintf("#<thread %s>", self.name)
+}
+
+// The result of a computation running in the background. Any error it raises
+// is kept until the future is touched.
+type Future struct {
+	t *Thread
+}
+
+func NewFuture(th *Thread, f Function) *Future {
+	t := newThread(th.ctx, th.params)
+	t.quiet = true
+	t.start(f, EMPTY_LIST)
+	return &Future{t}
+}
+
+func (self *Future) Touch() interface{} {
+	return self.t.Join()
+}
+
+func (self *Future) Done() bool {
+	return self.t.Done()
+}
+
+func (self *Future) String() string {
+	return self.GoString()
+}
+
+func (self *Future) GoString() string {
+	return "#<future>"
+}
+
+// Threads started through a task group are waited for together. The first
+// failure cancels the rest of the group.
+type taskGroup struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wait   sync.WaitGroup
+	lock   sync.Mutex
+	closed bool
+	err    interface{}
+	failed bool
+}
+
+func newTaskGroup(parent context.Context) *taskGroup {
+	ctx, cancel := context.WithCancel(parent)
+	return &taskGroup{ctx: ctx, cancel: cancel}
+}
+
+func (self *taskGroup) Spawn(th *Thread, f Function, args interface{}) *Thread {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if self.closed {
+		Error("task group has finished")
+	}
+	res := newThread(self.ctx, th.params)
+	res.group = self
+	self.wait.Add(1)
+	res.start(f, args)
+	return res
+}
+
+func (self *taskGroup) fail(err interface{}) {
+	self.lock.Lock()
+	if !self.failed {
+		self.err, self.failed = err, true
+	}
+	self.lock.Unlock()
+	self.cancel()
+}
+
+// Waits for every thread in the group, raising the first failure if there
+// was one.
+func (self *taskGroup) Close() {
+	self.wait.Wait()
+	self.lock.Lock()
+	self.closed = true
+	self.lock.Unlock()
+	self.cancel()
+	if self.failed {
+		panic(self.err)
+	}
+}
+
+/*
+	Parameters
+*/
+
+// A parameter is called to get its value, which may be rebound for the
+// extent of a call by parameterize. Threads start with the bindings of the
+// thread that started them.
+type Parameter struct {
+	val  interface{}
+	conv Function
+}
+
+// Bindings made by parameterize, innermost first.
+type paramFrame struct {
+	param *Parameter
+	val   interface{}
+	next  *paramFrame
+}
+
+func NewParameter(val interface{}, conv Function) *Parameter {
+	return newParameter(mainThread(), val, conv)
+}
+
+func newParameter(th *Thread, val interface{}, conv Function) *Parameter {
+	res := &Parameter{conv: conv}
+	res.val = res.convert(th, val)
+	return res
+}
+
+func (self *Parameter) convert(th *Thread, val interface{}) interface{} {
+	if self.conv == nil {
+		return val
+	}
+	return callOn(th, self.conv, val)
+}
+
+// The value bound on th, or the value the parameter was made with if th is
+// nil.
+func (self *Parameter) Get(th *Thread) interface{} {
+	if th != nil {
+		for f := th.params; f != nil; f = f.next {
+			if f.param == self {
+				return f.val
+			}
+		}
+	}
+	return self.val
+}
+
+// Called from Go, which has no thread to hand, a parameter gives the value
+// it was made with.
+func (self *Parameter) Apply(args interface{}) interface{} {
+	return self.applyOn(nil, args)
+}
+
+func (self *Parameter) applyOn(th *Thread, args interface{}) interface{} {
+	if args != EMPTY_LIST {
+		ArgumentError(self, args)
+	}
+	return self.Get(th)
+}
+
+func (self *Parameter) String() string {
+	return self.GoString()
+}
+
+func (self *Parameter) GoString() string {
+	return "#<parameter>"
+}
+
+// Calls thk with each of params bound to the corresponding value.
+func Parameterize(th *Thread, params []*Parameter, vals []interface{}, thk Function) interface{} {
+	frame := th.params
+	for i, p := range params {
+		frame = &paramFrame{p, p.convert(th, vals[i]), frame}
+	}
+	saved := th.params
+	th.params = frame
+	defer func() { th.params = saved }()
+	return callOn(th, thk)
+}
+
+/*
+	Synchronisation
+*/
+
+// Go treats unlocking an unlocked mutex as a fatal error, so the lock state
+// is tracked to report it as an ordinary one instead.
+type Mutex struct {
+	m      sync.Mutex
+	locked int32
+}
+
+func (self *Mutex) Lock() {
+	self.m.Lock()
+	atomic.StoreInt32(&self.locked, 1)
+}
+
+func (self *Mutex) TryLock() bool {
+	if !self.m.TryLock() {
+		return false
+	}
+	atomic.StoreInt32(&self.locked, 1)
+	return true
+}
+
+func (self *Mutex) Unlock() {
+	if !atomic.CompareAndSwapInt32(&self.locked, 1, 0) {
+		Error("mutex is not locked")
+	}
+	self.m.Unlock()
+}
+
+func (self *Mutex) String() string {
+	return self.GoString()
+}
+
+func (self *Mutex) GoString() string {
+	return "#<mutex>"
+}
+
+type RWMutex struct {
+	m       sync.RWMutex
+	locked  int32
+	readers int32
+}
+
+func (self *RWMutex) Lock() {
+	self.m.Lock()
+	atomic.StoreInt32(&self.locked, 1)
+}
+
+func (self *RWMutex) Unlock() {
+	if !atomic.CompareAndSwapInt32(&self.locked, 1, 0) {
+		Error("rw-mutex is not locked")
+	}
+	self.m.Unlock()
+}
+
+func (self *RWMutex) RLock() {
+	self.m.RLock()
+	atomic.AddInt32(&self.readers, 1)
+}
+
+func (self *RWMutex) RUnlock() {
+	for {
+		n := atomic.LoadInt32(&self.readers)
+		if n == 0 {
+			Error("rw-mutex is not read locked")
+		}
+		if atomic.CompareAndSwapInt32(&self.readers, n, n-1) {
+			break
+		}
+	}
+	self.m.RUnlock()
+}
+
+func (self *RWMutex) String() string {
+	return self.GoString()
+}
+
+func (self *RWMutex) GoString() string {
+	return "#<rw-mutex>"
+}
+
+type WaitGroup struct {
+	wg sync.WaitGroup
+}
+
+func (self *WaitGroup) Add(n int) {
+	defer func() {
+		if err := recover(); err != nil {
+			Error(fmt.Sprint(err))
+		}
+	}()
+	self.wg.Add(n)
+}
+
+func (self *WaitGroup) Wait() {
+	self.wg.Wait()
+}
+
+func (self *WaitGroup) String() string {
+	return self.GoString()
+}
+
+func (self *WaitGroup) GoString() string {
+	return "#<wait-group>"
+}
+
+// A function that only calls the function it wraps the first time it is
+// called. Later calls return the same result.
+type Once struct {
+	once   sync.Once
+	f      Function
+	res    interface{}
+	err    interface{}
+	failed bool
+}
+
+func NewOnce(f Function) *Once {
+	return &Once{f: f}
+}
+
+func (self *Once) Apply(args interface{}) interface{} {
+	return self.do(func() interface{} { return self.f.Apply(args) })
+}
+
+func (self *Once) applyOn(th *Thread, args interface{}) interface{} {
+	return self.do(func() interface{} { return applyOn(th, self.f, args) })
+}
+
+func (self *Once) do(f func() interface{}) interface{} {
+	self.once.Do(func() {
+		defer func() {
+			if err := recover(); err != nil {
+				self.err, self.failed = err, true
+			}
+		}()
+		self.res = f()
+	})
+	if self.failed {
+		panic(self.err)
+	}
+	return self.res
+}
+
+func (self *Once) String() string {
+	return self.GoString()
+}
+
+func (self *Once) GoString() string {
+	return "#<once>"
+}
+
+// A box whose contents may be swapped atomically.
+type Atomic struct {
+	val unsafe.Pointer
+}
+
+func NewAtomic(v interface{}) *Atomic {
+	return &Atomic{unsafe.Pointer(&v)}
+}
+
+func (self *Atomic) Get() interface{} {
+	return *(*interface{})(atomic.LoadPointer(&self.val))
+}
+
+func (self *Atomic) Set(v interface{}) {
+	atomic.StorePointer(&self.val, unsafe.Pointer(&v))
+}
+
+func (self *Atomic) Swap(v interface{}) interface{} {
+	return *(*interface{})(atomic.SwapPointer(&self.val, unsafe.Pointer(&v)))
+}
+
+// Replaces the contents with f applied to them, trying again if another
+// thread changes them in the meantime.
+func (self *Atomic) Update(f func(interface{}) interface{}) interface{} {
+	for {
+		p := atomic.LoadPointer(&self.val)
+		v := f(*(*interface{})(p))
+		if atomic.CompareAndSwapPointer(&self.val, p, unsafe.Pointer(&v)) {
+			return v
+		}
+	}
+}
+
+// Replaces the contents with v if they are currently old (as judged by ==).
+func (self *Atomic) CompareAndSwap(old, v interface{}) bool {
+	for {
+		p := atomic.LoadPointer(&self.val)
+		if !True(eq(*(*interface{})(p), old)) {
+			return false
+		}
+		if atomic.CompareAndSwapPointer(&self.val, p, unsafe.Pointer(&v)) {
+			return true
+		}
+	}
+}
+
+func (self *Atomic) String() string {
+	return self.GoString()
+}
+
+func (self *Atomic) GoString() string {
+	return fmt.Sprintf("#<atomic %s>", toWrite("%#v", self.Get()))
+}
+
+/*
+	Tickers
+*/
+
+// Sends the time in milliseconds down a channel at regular intervals until
+// stopped.
+type Ticker struct {
+	t    *time.Ticker
+	c    chan interface{}
+	stop chan struct{}
+	once sync.Once
+}
+
+func NewTicker(d time.Duration) *Ticker {
+	res := &Ticker{
+		t:    time.NewTicker(d),
+		c:    make(chan interface{}, 1),
+		stop: make(chan struct{}),
+	}
+	go func() {
+		for {
+			select {
+			case t := <-res.t.C:
+				// drop ticks for slow receivers, as time.Ticker does
+				select {
+				case res.c <- timeToMs(t):
+				default:
+				}
+			case <-res.stop:
+				return
+			}
+		}
+	}()
+	return res
+}
+
+func (self *Ticker) Chan() chan interface{} {
+	return self.c
+}
+
+func (self *Ticker) Stop() {
+	self.once.Do(func() {
+		self.t.Stop()
+		close(self.stop)
+	})
+}
+
+func (self *Ticker) String() string {
+	return self.GoString()
+}
+
+func (self *Ticker) GoString() string {
+	return "#<ticker>"
+}
+
+func timeToMs(t time.Time) int {
+	return int(t.UnixNano() / int64(time.Millisecond))
+}
+
 /*
 	Custom types
 */
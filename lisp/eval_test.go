@@ -0,0 +1,36 @@
+package lisp
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// An expression and what it writes when evaluated. A result starting with
+// "!" is instead the start of the error it throws.
+type evalTest struct {
+	expr, want string
+}
+
+// The tests share an interpreter and run in order, so a test may use what an
+// earlier one defined.
+func checkEval(t *testing.T, tests []evalTest) {
+	t.Helper()
+	PreludePaths = []string{filepath.Join("..", PreludeFile)}
+	i := New()
+	for _, test := range tests {
+		got := evalWrite(i, test.expr)
+		if got != test.want && !(strings.HasPrefix(test.want, "!") && strings.HasPrefix(got, test.want)) {
+			t.Errorf("%s => %s, want %s", test.expr, got, test.want)
+		}
+	}
+}
+
+func evalWrite(i *Scope, expr string) (res string) {
+	defer func() {
+		if err := recover(); err != nil {
+			res = "!" + toWrite("%#v", err)
+		}
+	}()
+	return toWrite("%#v", i.EvalString(expr))
+}
@@ -7,6 +7,8 @@ import (
 	"os/user"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/bobappleyard/bwl/errors"
 )
@@ -35,6 +37,23 @@ func init() {
 type Scope struct {
 	env    Environment
 	parent *Scope
+	lock   sync.RWMutex
+	thread *Thread
+	// made once by New, as they are buffered
+	stdin  *InputPort
+	stdout *OutputPort
+}
+
+// Set once a second goroutine may be evaluating code. Until then scopes are
+// accessed without taking their locks.
+var threaded int32
+
+func enterThreaded() {
+	atomic.StoreInt32(&threaded, 1)
+}
+
+func isThreaded() bool {
+	return atomic.LoadInt32(&threaded) != 0
 }
 
 type closure struct {
@@ -51,9 +70,14 @@ type tailStruct struct {
 	args *interface{}
 }
 
-// Create a new execution Scope for some code.
+// Create a new execution Scope for some code. A scope with no parent keeps
+// the thread that code given to it from Go runs on.
 func NewScope(parent *Scope) *Scope {
-	return &Scope{make(Environment), parent}
+	res := &Scope{env: make(Environment), parent: parent}
+	if parent == nil {
+		res.thread = mainThread()
+	}
+	return res
 }
 
 // patchy workaround...
@@ -72,8 +96,11 @@ func tryLoad(paths []string) string {
 func New() *Scope {
 	res := NewScope(nil)
 	res.Bind(Primitives())
+	res.stdin, res.stdout = NewInput(os.Stdin), NewOutput(os.Stdout)
 	res.Bind(WrapPrimitives(map[string]interface{}{
 		"root-environment": func() interface{} { return res },
+		"standard-input":   func() interface{} { return res.stdin },
+		"standard-output":  func() interface{} { return res.stdout },
 	}))
 	if PreludePath := tryLoad(PreludePaths); PreludePath != "" {
 		res.Load(PreludePath)
@@ -93,8 +120,16 @@ func (self *Scope) GoString() string {
 	return "#<environment>"
 }
 
+// Code evaluated from Go runs on the root scope's thread, so bindings made
+// there last from one call to the next. It should be evaluated from one
+// goroutine at a time.
 func (self *Scope) Eval(x interface{}) interface{} {
-	return self.evalExpr(self.Expand(x), nil)
+	defer self.flush()
+	return self.eval(self.root().thread, x)
+}
+
+func (self *Scope) eval(th *Thread, x interface{}) interface{} {
+	return self.evalExpr(th, self.expand(th, x), nil)
 }
 
 func (self *Scope) EvalString(x string) interface{} {
@@ -102,6 +137,10 @@ func (self *Scope) EvalString(x string) interface{} {
 }
 
 func (self *Scope) Expand(x interface{}) interface{} {
+	return self.expand(self.root().thread, x)
+}
+
+func (self *Scope) expand(th *Thread, x interface{}) interface{} {
 	done := false
 	for !done {
 		p, ok := x.(*Pair)
@@ -113,40 +152,50 @@ func (self *Scope) Expand(x interface{}) interface{} {
 			case "quote":
 				return x
 			case "if":
-				return Cons(p.a, self.expandList(p.d))
+				return Cons(p.a, self.expandList(th, p.d))
 			case "lambda":
 				{
 					ctx := NewScope(self)
-					return Cons(p.a, Cons(Car(p.d), ctx.expandList(Cdr(p.d))))
+					return Cons(p.a, Cons(Car(p.d), ctx.expandList(th, Cdr(p.d))))
 				}
 			case "set!":
-				return List(p.a, Car(p.d), self.Expand(Car(Cdr(p.d))))
+				return List(p.a, Car(p.d), self.expand(th, Car(Cdr(p.d))))
 			case "define":
-				return Cons(p.a, self.expandDefinition(p.d))
+				return Cons(p.a, self.expandDefinition(th, p.d))
 			case "define-macro":
 				{
-					expr := self.expandDefinition(p.d)
+					expr := self.expandDefinition(th, p.d)
 					expr = List(Symbol("define"), Car(expr), Cons(Symbol("macro"), Cdr(expr)))
-					self.evalExpr(expr, nil)
+					self.evalExpr(th, expr, nil)
 					return expr
 				}
 			case "begin":
-				return Cons(p.a, self.expandList(p.d))
+				return Cons(p.a, self.expandList(th, p.d))
+			case "unwind-protect":
+				return Cons(p.a, self.expandList(th, p.d))
+			}
+			if m, ok := self.lookupMacro(s); ok {
+				x = applyOn(th, m.f, p.d)
+			} else {
+				x, done = self.expandList(th, x), true
 			}
-			errors.Catch(
-				func() { x = self.lookupSym(s).(*macro).f.Apply(p.d) },
-				func(_ interface{}) { x, done = self.expandList(x), true },
-			)
 		} else {
-			x, done = self.expandList(x), true
+			x, done = self.expandList(th, x), true
 		}
 	}
 	return x
 }
 
+func (self *Scope) root() *Scope {
+	for self.parent != nil {
+		self = self.parent
+	}
+	return self
+}
+
 func (self *Scope) Bind(env Environment) {
 	for k, v := range env {
-		self.env[k] = v
+		self.define(k, v)
 	}
 }
 
@@ -155,19 +204,39 @@ func (self *Scope) Lookup(x string) interface{} {
 }
 
 func (self *Scope) Load(path string) {
+	defer self.flush()
+	self.load(self.root().thread, path)
+}
+
+// Output to the standard output port is flushed once control returns to Go.
+func (self *Scope) flush() {
+	if out := self.root().stdout; out != nil {
+		out.Flush()
+	}
+}
+
+func (self *Scope) load(th *Thread, path string) {
 	src := openFile(path, Symbol("read"))
-	exprs := ReadFile(src)
+	exprs := readFileOn(th, src)
 	for cur := exprs; cur != EMPTY_LIST; cur = Cdr(cur) {
-		self.Eval(Car(cur))
+		self.eval(th, Car(cur))
 	}
 }
 
 func (self *Scope) Repl(in io.Reader, out io.Writer) {
 	// set stuff up
-	inp := NewInput(in)
-	outp := NewOutput(out)
+	root := self.root()
+	th := root.thread
+	// the standard ports buffer, so are shared rather than made again
+	inp, outp := root.stdin, root.stdout
+	if in != os.Stdin || inp == nil {
+		inp = NewInput(in)
+	}
+	if out != os.Stdout || outp == nil {
+		outp = NewOutput(out)
+	}
 	read := func() interface{} {
-		res := inp.ReadLine()
+		res := inp.readLineOn(th)
 		if res == EOF_OBJECT {
 			return nil
 		}
@@ -182,34 +251,36 @@ func (self *Scope) Repl(in io.Reader, out io.Writer) {
 		return res
 	}
 	self.Bind(WrapPrimitives(map[string]interface{}{
-		"standard-input":  func() interface{} { return inp },
-		"standard-output": func() interface{} { return outp },
+		"standard-input":      func() interface{} { return inp },
+		"standard-output":     func() interface{} { return outp },
+		"current-input-port":  NewParameter(inp, nil),
+		"current-output-port": NewParameter(outp, nil),
 	}))
 	// main loop
 	var x interface{}
-	for !inp.Eof() {
+	for !inp.eofOn(th) {
 		errors.Catch(
 			func() {
-				Display("> ", outp)
-				outp.Flush()
-				x = self.Eval(read())
+				displayOn(th, "> ", outp)
+				outp.flushOn(th)
+				x = self.eval(th, read())
 			},
 			func(err interface{}) { x = err },
 		)
 		if x != nil {
-			Write(x, outp)
-			Display("\n", outp)
+			writeOn(th, x, outp)
+			displayOn(th, "\n", outp)
 		}
 	}
-	Display("\n", outp)
-	outp.Flush()
+	displayOn(th, "\n", outp)
+	outp.flushOn(th)
 }
 
-func (self *Scope) evalExpr(_x interface{}, tail *tailStruct) interface{} {
+func (self *Scope) evalExpr(th *Thread, _x interface{}, tail *tailStruct) interface{} {
 	// pairs and symbols get treated specially
 	switch x := _x.(type) {
 	case *Pair:
-		return self.evalPair(x, tail)
+		return self.evalPair(th, x, tail)
 	case Symbol:
 		return self.lookupSym(x)
 	}
@@ -217,7 +288,7 @@ func (self *Scope) evalExpr(_x interface{}, tail *tailStruct) interface{} {
 	return _x
 }
 
-func (self *Scope) evalPair(x *Pair, tail *tailStruct) interface{} {
+func (self *Scope) evalPair(th *Thread, x *Pair, tail *tailStruct) interface{} {
 	switch n := x.a.(type) {
 	case Symbol:
 		switch string(n) {
@@ -225,26 +296,28 @@ func (self *Scope) evalPair(x *Pair, tail *tailStruct) interface{} {
 		case "quote":
 			return Car(x.d)
 		case "if":
-			if True(self.evalExpr(ListRef(x.d, 0), nil)) {
-				return self.evalExpr(ListRef(x.d, 1), tail)
+			if True(self.evalExpr(th, ListRef(x.d, 0), nil)) {
+				return self.evalExpr(th, ListRef(x.d, 1), tail)
 			} else {
-				return self.evalExpr(ListRef(x.d, 2), tail)
+				return self.evalExpr(th, ListRef(x.d, 2), tail)
 			}
 		case "lambda":
 			return &closure{self, Car(x.d), Cdr(x.d)}
 		case "set!":
 			{
-				v := self.evalExpr(ListRef(x.d, 1), nil)
+				v := self.evalExpr(th, ListRef(x.d, 1), nil)
 				self.mutate(Car(x.d), v)
 				return nil
 			}
 		case "define":
 			{
-				self.evalDefine(x.d)
+				self.evalDefine(th, x.d)
 				return nil
 			}
 		case "begin":
-			return self.evalBlock(x.d, tail)
+			return self.evalBlock(th, x.d, tail)
+		case "unwind-protect":
+			return self.evalUnwindProtect(th, x.d)
 			// otherwise fall through to a function call
 		}
 	case *Pair: // do nothing, it's handled below
@@ -252,20 +325,70 @@ func (self *Scope) evalPair(x *Pair, tail *tailStruct) interface{} {
 		TypeError("pair or symbol", n)
 	}
 	// function application
-	return self.evalCall(self.evalExpr(x.a, nil), x.d, tail)
+	return self.evalCall(th, self.evalExpr(th, x.a, nil), x.d, tail)
 }
 
 func (self *Scope) lookupSym(x Symbol) interface{} {
 	if self == nil {
 		Error(fmt.Sprintf("unknown variable: %s", x))
 	}
-	res, ok := self.env[x]
+	res, ok := self.get(x)
 	if ok {
 		return res
 	}
 	return self.parent.lookupSym(x)
 }
 
+// Unlike lookupSym, does not raise an error if s is unbound.
+func (self *Scope) lookupMacro(s Symbol) (*macro, bool) {
+	for cur := self; cur != nil; cur = cur.parent {
+		if res, ok := cur.get(s); ok {
+			m, ok := res.(*macro)
+			return m, ok
+		}
+	}
+	return nil, false
+}
+
+func (self *Scope) get(name Symbol) (interface{}, bool) {
+	if !isThreaded() {
+		res, ok := self.env[name]
+		return res, ok
+	}
+	self.lock.RLock()
+	res, ok := self.env[name]
+	self.lock.RUnlock()
+	return res, ok
+}
+
+func (self *Scope) define(name Symbol, val interface{}) {
+	if !isThreaded() {
+		self.env[name] = val
+		return
+	}
+	self.lock.Lock()
+	self.env[name] = val
+	self.lock.Unlock()
+}
+
+// Sets name if it is bound in this scope, reporting whether it was.
+func (self *Scope) assign(name Symbol, val interface{}) bool {
+	if !isThreaded() {
+		_, ok := self.env[name]
+		if ok {
+			self.env[name] = val
+		}
+		return ok
+	}
+	self.lock.Lock()
+	_, ok := self.env[name]
+	if ok {
+		self.env[name] = val
+	}
+	self.lock.Unlock()
+	return ok
+}
+
 func (self *Scope) mutate(_name, val interface{}) {
 	if self == nil {
 		Error(fmt.Sprintf("unknown variable: %s", _name))
@@ -274,14 +397,12 @@ func (self *Scope) mutate(_name, val interface{}) {
 	if !ok {
 		TypeError("symbol", _name)
 	}
-	_, ok = self.env[name]
-	if !ok {
+	if !self.assign(name, val) {
 		self.parent.mutate(_name, val)
 	}
-	self.env[name] = val
 }
 
-func (self *Scope) evalCall(f, args interface{}, tail *tailStruct) interface{} {
+func (self *Scope) evalCall(th *Thread, f, args interface{}, tail *tailStruct) interface{} {
 	var argvals interface{} = EMPTY_LIST
 	p := new(Pair)
 	// evaluate the arguments
@@ -289,7 +410,7 @@ func (self *Scope) evalCall(f, args interface{}, tail *tailStruct) interface{} {
 		if argvals == EMPTY_LIST {
 			argvals = p
 		}
-		r := self.evalExpr(Car(cur), nil)
+		r := self.evalExpr(th, Car(cur), nil)
 		p.a = r
 		if Cdr(cur) == EMPTY_LIST {
 			p.d = EMPTY_LIST
@@ -306,7 +427,7 @@ func (self *Scope) evalCall(f, args interface{}, tail *tailStruct) interface{} {
 	}
 	// call it
 	if tail == nil {
-		return fn.Apply(argvals)
+		return applyOn(th, fn, argvals)
 	}
 	// in tail position
 	*(tail.f) = fn
@@ -314,44 +435,52 @@ func (self *Scope) evalCall(f, args interface{}, tail *tailStruct) interface{} {
 	return nil
 }
 
-func (self *Scope) evalDefine(ls interface{}) {
+func (self *Scope) evalDefine(th *Thread, ls interface{}) {
 	d := Car(ls)
 	n, ok := d.(Symbol)
 	if !ok {
 		TypeError("symbol", d)
 	}
 	d = Car(Cdr(ls))
-	d = self.evalExpr(d, nil)
-	self.env[n] = d
+	d = self.evalExpr(th, d, nil)
+	self.define(n, d)
 }
 
-func (self *Scope) evalBlock(body interface{}, tail *tailStruct) interface{} {
+func (self *Scope) evalBlock(th *Thread, body interface{}, tail *tailStruct) interface{} {
 	var res interface{}
 	for cur := body; cur != EMPTY_LIST; cur = Cdr(cur) {
 		if Cdr(cur) == EMPTY_LIST { // in tail position
-			res = self.evalExpr(Car(cur), tail)
+			res = self.evalExpr(th, Car(cur), tail)
 		} else {
-			self.evalExpr(Car(cur), nil)
+			self.evalExpr(th, Car(cur), nil)
 		}
 	}
 	return res
 }
 
-func (self *Scope) expandList(ls interface{}) interface{} {
+// The cleanup forms run however the protected form is left, including by
+// panics from Go code, which carry on unchanged once they are done. The
+// protected form is not in tail position, as that would leave it early.
+func (self *Scope) evalUnwindProtect(th *Thread, x interface{}) interface{} {
+	defer self.evalBlock(th, Cdr(x), nil)
+	return self.evalExpr(th, Car(x), nil)
+}
+
+func (self *Scope) expandList(th *Thread, ls interface{}) interface{} {
 	var res interface{} = EMPTY_LIST
 	p := new(Pair)
 	for cur := ls; cur != EMPTY_LIST; cur = Cdr(cur) {
 		if res == EMPTY_LIST {
 			res = p
 		}
-		p.a = self.Expand(Car(cur))
+		p.a = self.expand(th, Car(cur))
 		next := new(Pair)
 		if Cdr(cur) == EMPTY_LIST {
 			p.d = EMPTY_LIST
 			break
 		}
 		if _, ok := Cdr(cur).(*Pair); !ok {
-			p.d = self.Expand(Cdr(cur))
+			p.d = self.expand(th, Cdr(cur))
 			break
 		}
 		p.d = next
@@ -360,12 +489,12 @@ func (self *Scope) expandList(ls interface{}) interface{} {
 	return res
 }
 
-func (self *Scope) expandDefinition(ls interface{}) interface{} {
+func (self *Scope) expandDefinition(th *Thread, ls interface{}) interface{} {
 	for {
 		if p, ok := Car(ls).(*Pair); ok {
 			ls = List(p.a, Cons(Symbol("lambda"), Cons(p.d, Cdr(ls))))
 		} else {
-			ls = Cons(Car(ls), self.expandList(Cdr(ls)))
+			ls = Cons(Car(ls), self.expandList(th, Cdr(ls)))
 			break
 		}
 	}
@@ -383,6 +512,10 @@ func (self *closure) GoString() string {
 }
 
 func (self *closure) Apply(args interface{}) interface{} {
+	return self.applyOn(self.ctx.root().thread, args)
+}
+
+func (self *closure) applyOn(th *Thread, args interface{}) interface{} {
 	var res interface{}
 	var f Function = self
 	// closures can tail recurse, the for loop captures this
@@ -392,10 +525,10 @@ func (self *closure) Apply(args interface{}) interface{} {
 			f = nil
 			ctx := NewScope(cl.ctx)
 			cl.bindArgs(ctx, args)
-			res = ctx.evalBlock(cl.body, tail)
+			res = ctx.evalBlock(th, cl.body, tail)
 		} else {
 			// primitive functions, or whatever
-			return f.Apply(args)
+			return applyOn(th, f, args)
 		}
 	}
 	return res
@@ -428,7 +561,7 @@ func (self *closure) bindArg(ctx *Scope, name, val interface{}) {
 	if !ok {
 		TypeError("symbol", name)
 	}
-	ctx.env[n] = val
+	ctx.define(n, val)
 }
 
 // Macros
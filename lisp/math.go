@@ -0,0 +1,482 @@
+package lisp
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"math/bits"
+	"math/cmplx"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+/*
+	Roots and powers
+*/
+
+func toInteger(x interface{}) *big.Int {
+	switch n := x.(type) {
+	case int:
+		return big.NewInt(int64(n))
+	case *big.Int:
+		return n
+	}
+	TypeError("integer", x)
+	panic("unreachable")
+}
+
+// The exact square root of n, if it has one.
+func exactSqrt(n *big.Int) (*big.Int, bool) {
+	if n.Sign() < 0 {
+		return nil, false
+	}
+	s := new(big.Int).Sqrt(n)
+	return s, new(big.Int).Mul(s, s).Cmp(n) == 0
+}
+
+// Exact numbers with exact square roots give exact results. Negative
+// numbers have complex roots.
+func sqrt(th *Thread, x interface{}) interface{} {
+	if numLevel(x) == complexLevel || isNegative(x) {
+		return normComplex(cmplx.Sqrt(toComplex(x)))
+	}
+	switch n := x.(type) {
+	case int, *big.Int:
+		if s, ok := exactSqrt(toInteger(n)); ok {
+			return normBig(s)
+		}
+	case *big.Rat:
+		if a, ok := exactSqrt(n.Num()); ok {
+			if b, ok := exactSqrt(n.Denom()); ok {
+				return normRat(new(big.Rat).SetFrac(a, b))
+			}
+		}
+	case *big.Float:
+		return bigfloatSqrt(th, n)
+	}
+	return math.Sqrt(toFlonum(x))
+}
+
+// Returns (s r) where s is the largest integer whose square is no more than
+// k, and r is what's left.
+func exactIntegerSqrt(k interface{}) interface{} {
+	n := toInteger(k)
+	if n.Sign() < 0 {
+		TypeError("non-negative integer", k)
+	}
+	s := new(big.Int).Sqrt(n)
+	r := new(big.Int).Sub(n, new(big.Int).Mul(s, s))
+	return List(normBig(s), normBig(r))
+}
+
+// Raising an exact number to a fixnum power gives an exact result, and a
+// bigfloat to a fixnum power a bigfloat. math/big has no logarithms, so
+// other powers of bigfloats are taken with flonums and have only their
+// precision.
+func expt(th *Thread, base, power interface{}) interface{} {
+	p, ok := power.(int)
+	if b, isBig := base.(*big.Float); isBig && ok {
+		return bigfloatExpt(th, b, p)
+	}
+	if numLevel(base) == complexLevel || numLevel(power) == complexLevel {
+		return normComplex(cmplx.Pow(toComplex(base), toComplex(power)))
+	}
+	if !ok || numLevel(base) >= flonumLevel {
+		res := math.Pow(toFlonum(base), toFlonum(power))
+		if math.IsNaN(res) && isNegative(base) {
+			return normComplex(cmplx.Pow(toComplex(base), toComplex(power)))
+		}
+		return res
+	}
+	var num, den *big.Int
+	switch b := base.(type) {
+	case int, *big.Int:
+		num, den = toInteger(b), big.NewInt(1)
+	case *big.Rat:
+		num, den = b.Num(), b.Denom()
+	}
+	if p < 0 {
+		checkDivisor(num.Sign() == 0)
+		num, den, p = den, num, -p
+	}
+	e := big.NewInt(int64(p))
+	num = new(big.Int).Exp(num, e, nil)
+	den = new(big.Int).Exp(den, e, nil)
+	return normRat(new(big.Rat).SetFrac(num, den))
+}
+
+func exp(x interface{}) interface{} {
+	if c, ok := x.(complex128); ok {
+		return normComplex(cmplx.Exp(c))
+	}
+	return math.Exp(toFlonum(x))
+}
+
+// Bignums too large to be flonums are scaled down first.
+func ln(x interface{}) float64 {
+	if n, ok := x.(*big.Int); ok && n.Sign() > 0 && n.BitLen() > 1000 {
+		shift := uint(n.BitLen() - 64)
+		return ln(new(big.Int).Rsh(n, shift)) + float64(shift)*math.Ln2
+	}
+	return math.Log(toFlonum(x))
+}
+
+// Logarithms of complex and negative numbers are complex.
+func log(x, base interface{}) interface{} {
+	complexLog := numLevel(x) == complexLevel || isNegative(x)
+	if base != false {
+		complexLog = complexLog || numLevel(base) == complexLevel || isNegative(base)
+	}
+	switch {
+	case !complexLog && base == false:
+		return ln(x)
+	case !complexLog:
+		return ln(x) / ln(base)
+	case base == false:
+		return normComplex(cmplx.Log(toComplex(x)))
+	}
+	return normComplex(cmplx.Log(toComplex(x)) / cmplx.Log(toComplex(base)))
+}
+
+/*
+	Trigonometry
+*/
+
+func flonumFn(f func(float64) float64, cf func(complex128) complex128) func(x interface{}) interface{} {
+	return func(x interface{}) interface{} {
+		if c, ok := x.(complex128); ok {
+			return normComplex(cf(c))
+		}
+		return f(toFlonum(x))
+	}
+}
+
+func atan(y, x interface{}) interface{} {
+	if x == false {
+		return math.Atan(toFlonum(y))
+	}
+	return math.Atan2(toFlonum(y), toFlonum(x))
+}
+
+/*
+	Rounding
+
+	Exact numbers round to exact integers, flonums to whole flonums.
+*/
+
+func roundFn(flo func(float64) float64, rat func(n, d *big.Int) *big.Int) func(th *Thread, x interface{}) interface{} {
+	return func(th *Thread, x interface{}) interface{} {
+		switch n := x.(type) {
+		case int, *big.Int:
+			return n
+		case *big.Rat:
+			return normBig(rat(n.Num(), n.Denom()))
+		case float64:
+			return flo(n)
+		case *big.Float:
+			r, _ := n.Rat(nil)
+			if r == nil || r.IsInt() {
+				return n
+			}
+			return bigfloatOp(th, func(z *big.Float) {
+				z.SetInt(rat(r.Num(), r.Denom()))
+			})
+		}
+		TypeError("number", x)
+		panic("unreachable")
+	}
+}
+
+// Denominators are always positive, so Div rounds down.
+func floorRat(n, d *big.Int) *big.Int {
+	return new(big.Int).Div(n, d)
+}
+
+func ceilingRat(n, d *big.Int) *big.Int {
+	res := floorRat(n, d)
+	return res.Add(res, big.NewInt(1))
+}
+
+func truncateRat(n, d *big.Int) *big.Int {
+	return new(big.Int).Quo(n, d)
+}
+
+// Halves go to the even neighbour.
+func roundRat(n, d *big.Int) *big.Int {
+	twice := new(big.Int).Lsh(n, 1)
+	twice.Add(twice, d)
+	res := floorRat(twice, new(big.Int).Lsh(d, 1))
+	// exactly half way, when 2n + d is divisible by 2d
+	if new(big.Int).Mod(twice, new(big.Int).Lsh(d, 1)).Sign() == 0 && res.Bit(0) == 1 {
+		res.Sub(res, big.NewInt(1))
+	}
+	return res
+}
+
+var (
+	floor    = roundFn(math.Floor, floorRat)
+	ceiling  = roundFn(math.Ceil, ceilingRat)
+	truncate = roundFn(math.Trunc, truncateRat)
+	round    = roundFn(math.RoundToEven, roundRat)
+)
+
+/*
+	Number theory
+*/
+
+func gcd(args ...interface{}) interface{} {
+	res := new(big.Int)
+	for _, x := range args {
+		res.GCD(nil, nil, res, toInteger(x))
+	}
+	return normBig(res)
+}
+
+func lcm(args ...interface{}) interface{} {
+	res := big.NewInt(1)
+	for _, x := range args {
+		n := new(big.Int).Abs(toInteger(x))
+		if n.Sign() == 0 {
+			return 0
+		}
+		g := new(big.Int).GCD(nil, nil, res, n)
+		res.Mul(res, n.Quo(n, g))
+	}
+	return normBig(res)
+}
+
+/*
+	Conversion to and from strings
+*/
+
+func toRadix(radix interface{}) int {
+	if radix == false {
+		return 10
+	}
+	r, ok := radix.(int)
+	if !ok || r < 2 || r > 36 {
+		TypeError("radix", radix)
+	}
+	return r
+}
+
+func numberToString(x, radix interface{}) interface{} {
+	r := toRadix(radix)
+	switch n := x.(type) {
+	case int:
+		return strconv.FormatInt(int64(n), r)
+	case *big.Int:
+		return n.Text(r)
+	case *big.Rat:
+		return n.Num().Text(r) + "/" + n.Denom().Text(r)
+	case float64:
+		if r != 10 {
+			Error(fmt.Sprintf("flonums can only be written in decimal: %d", r))
+		}
+		return formatFlonum(n)
+	case *big.Float:
+		if r != 10 {
+			Error(fmt.Sprintf("bigfloats can only be written in decimal: %d", r))
+		}
+		return formatBigfloat(n)
+	case complex128:
+		if r != 10 {
+			Error(fmt.Sprintf("complex numbers can only be written in decimal: %d", r))
+		}
+		return formatComplex(n)
+	}
+	TypeError("number", x)
+	panic("unreachable")
+}
+
+var (
+	flonumSyntax   = regexp.MustCompile(`^[-+]?(\d+\.?\d*|\.\d+)([eE][-+]?\d+)?$`)
+	bigfloatSyntax = regexp.MustCompile(`^[-+]?(\d+\.?\d*|\.\d+)[lL][-+]?\d+$`)
+)
+
+func parseInteger(s string, radix int) (*big.Int, bool) {
+	if strings.HasPrefix(s, "+") {
+		s = s[1:]
+		if strings.HasPrefix(s, "-") {
+			return nil, false
+		}
+	}
+	return new(big.Int).SetString(s, radix)
+}
+
+// Gives #f if s is not a number.
+func stringToNumber(th *Thread, str, radix interface{}) interface{} {
+	s, ok := str.(string)
+	if !ok {
+		TypeError("string", str)
+	}
+	r := toRadix(radix)
+	if n, ok := parseInteger(s, r); ok {
+		return normBig(n)
+	}
+	if i := strings.Index(s, "/"); i != -1 {
+		num, ok1 := parseInteger(s[:i], r)
+		den, ok2 := parseInteger(s[i+1:], r)
+		if ok1 && ok2 && den.Sign() > 0 && !strings.HasPrefix(s[i+1:], "-") {
+			return normRat(new(big.Rat).SetFrac(num, den))
+		}
+		return false
+	}
+	if r != 10 {
+		return false
+	}
+	switch s {
+	case "+inf.0":
+		return math.Inf(1)
+	case "-inf.0":
+		return math.Inf(-1)
+	case "+nan.0", "-nan.0":
+		return math.NaN()
+	}
+	if bigfloatSyntax.MatchString(s) {
+		if res, ok := parseBigfloat(th, s); ok {
+			return res
+		}
+		return false
+	}
+	if c, ok := parseComplex(s); ok {
+		return normComplex(c)
+	}
+	if !flonumSyntax.MatchString(s) {
+		return false
+	}
+	res, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return false
+	}
+	return res
+}
+
+/*
+	Bitwise operations
+
+	Integers behave as if they were in two's complement, with an infinite
+	number of sign bits.
+*/
+
+var andOps = &numOps{
+	fix: func(a, b int) (interface{}, bool) { return a & b, true },
+	big: func(a, b *big.Int) interface{} {
+		return normBig(new(big.Int).And(a, b))
+	},
+}
+
+var orOps = &numOps{
+	fix: func(a, b int) (interface{}, bool) { return a | b, true },
+	big: func(a, b *big.Int) interface{} {
+		return normBig(new(big.Int).Or(a, b))
+	},
+}
+
+var xorOps = &numOps{
+	fix: func(a, b int) (interface{}, bool) { return a ^ b, true },
+	big: func(a, b *big.Int) interface{} {
+		return normBig(new(big.Int).Xor(a, b))
+	},
+}
+
+func bitwiseFold(unit interface{}, ops *numOps, args []interface{}) interface{} {
+	res := unit
+	for _, x := range args {
+		res = intArith(res, x, ops)
+	}
+	return res
+}
+
+func bitwiseAnd(args ...interface{}) interface{} {
+	return bitwiseFold(-1, andOps, args)
+}
+
+func bitwiseOr(args ...interface{}) interface{} {
+	return bitwiseFold(0, orOps, args)
+}
+
+func bitwiseXor(args ...interface{}) interface{} {
+	return bitwiseFold(0, xorOps, args)
+}
+
+func bitwiseNot(x interface{}) interface{} {
+	if n, ok := x.(int); ok {
+		return ^n
+	}
+	return normBig(new(big.Int).Not(toInteger(x)))
+}
+
+// Shifts left for positive counts and right for negative ones.
+func arithmeticShift(x, count interface{}) interface{} {
+	c, ok := count.(int)
+	if !ok {
+		TypeError("fixnum", count)
+	}
+	if n, ok := x.(int); ok {
+		switch {
+		case c <= 0 && c > -bits.UintSize:
+			return n >> uint(-c)
+		case c <= 0 && n < 0:
+			return -1
+		case c <= 0:
+			return 0
+		case c < bits.UintSize && (n<<uint(c))>>uint(c) == n:
+			return n << uint(c)
+		}
+	}
+	n := toInteger(x)
+	if c < 0 {
+		return normBig(new(big.Int).Rsh(n, uint(-c)))
+	}
+	return normBig(new(big.Int).Lsh(n, uint(c)))
+}
+
+// The number of bits that differ from the sign bit.
+func bitCount(x interface{}) interface{} {
+	if n, ok := x.(int); ok {
+		if n < 0 {
+			n = ^n
+		}
+		return bits.OnesCount(uint(n))
+	}
+	n := toInteger(x)
+	if n.Sign() < 0 {
+		n = new(big.Int).Not(n)
+	}
+	res := 0
+	for _, w := range n.Bits() {
+		res += bits.OnesCount(uint(w))
+	}
+	return res
+}
+
+func bitSet(index, x interface{}) interface{} {
+	i, ok := index.(int)
+	if !ok || i < 0 {
+		TypeError("non-negative fixnum", index)
+	}
+	if n, ok := x.(int); ok {
+		if i >= bits.UintSize {
+			return n < 0
+		}
+		return n>>uint(i)&1 == 1
+	}
+	return toInteger(x).Bit(i) == 1
+}
+
+// The number of bits needed to represent x, not counting the sign bit.
+func integerLength(x interface{}) interface{} {
+	if n, ok := x.(int); ok {
+		if n < 0 {
+			n = ^n
+		}
+		return bits.Len(uint(n))
+	}
+	n := toInteger(x)
+	if n.Sign() < 0 {
+		n = new(big.Int).Not(n)
+	}
+	return n.BitLen()
+}
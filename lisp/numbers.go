@@ -0,0 +1,695 @@
+package lisp
+
+import (
+	"math"
+	"math/big"
+)
+
+/*
+	Numeric tower
+
+	Numbers are fixnums (int), bignums (*big.Int), ratnums (*big.Rat),
+	flonums (float64), bigfloats (*big.Float) and complex numbers
+	(complex128), in that order. Arithmetic on two numbers first converts
+	the lower of them to the level of the higher. Exact results are always
+	given at the lowest level that can represent them, so integers are
+	fixnums whenever they fit and ratnums are never whole.
+*/
+
+const (
+	fixnumLevel = iota
+	bignumLevel
+	ratnumLevel
+	flonumLevel
+	bigfloatLevel
+	complexLevel
+)
+
+func numLevel(x interface{}) int {
+	switch x.(type) {
+	case int:
+		return fixnumLevel
+	case *big.Int:
+		return bignumLevel
+	case *big.Rat:
+		return ratnumLevel
+	case float64:
+		return flonumLevel
+	case *big.Float:
+		return bigfloatLevel
+	case complex128:
+		return complexLevel
+	}
+	TypeError("number", x)
+	panic("unreachable")
+}
+
+func isNumber(x interface{}) bool {
+	switch x.(type) {
+	case int, *big.Int, *big.Rat, float64, *big.Float, complex128:
+		return true
+	}
+	return false
+}
+
+// Converts x to the given level, which must be no lower than its own.
+func toLevel(th *Thread, x interface{}, level int) interface{} {
+	switch level {
+	case bignumLevel:
+		if n, ok := x.(int); ok {
+			return big.NewInt(int64(n))
+		}
+	case ratnumLevel:
+		switch n := x.(type) {
+		case int:
+			return new(big.Rat).SetInt64(int64(n))
+		case *big.Int:
+			return new(big.Rat).SetInt(n)
+		}
+	case flonumLevel:
+		return toFlonum(x)
+	case bigfloatLevel:
+		return toBigfloat(th, x)
+	case complexLevel:
+		return toComplex(x)
+	}
+	return x
+}
+
+func toFlonum(x interface{}) float64 {
+	switch n := x.(type) {
+	case int:
+		return float64(n)
+	case *big.Int:
+		f, _ := new(big.Float).SetInt(n).Float64()
+		return f
+	case *big.Rat:
+		f, _ := n.Float64()
+		return f
+	case float64:
+		return n
+	case *big.Float:
+		f, _ := n.Float64()
+		return f
+	case complex128:
+		TypeError("real number", x)
+	}
+	TypeError("number", x)
+	panic("unreachable")
+}
+
+// Demotes n to a fixnum if it will fit.
+func normBig(n *big.Int) interface{} {
+	if n.IsInt64() {
+		if v := n.Int64(); int64(int(v)) == v {
+			return int(v)
+		}
+	}
+	return n
+}
+
+// Demotes n to an integer if it is whole.
+func normRat(n *big.Rat) interface{} {
+	if n.IsInt() {
+		return normBig(new(big.Int).Set(n.Num()))
+	}
+	return n
+}
+
+// An arithmetic operation, at each level of the tower. The fixnum version
+// reports false if the result overflows, in which case the operation is
+// done on bignums instead. Bigfloat results depend on the parameters of the
+// thread doing the arithmetic.
+type numOps struct {
+	fix  func(a, b int) (interface{}, bool)
+	big  func(a, b *big.Int) interface{}
+	rat  func(a, b *big.Rat) interface{}
+	flo  func(a, b float64) interface{}
+	bigf func(th *Thread, a, b *big.Float) interface{}
+	cpx  func(a, b complex128) interface{}
+}
+
+func arith(th *Thread, a, b interface{}, ops *numOps) interface{} {
+	level := numLevel(a)
+	if l := numLevel(b); l > level {
+		level = l
+	}
+	a, b = toLevel(th, a, level), toLevel(th, b, level)
+	switch level {
+	case fixnumLevel:
+		return fixArith(a.(int), b.(int), ops)
+	case bignumLevel:
+		return ops.big(a.(*big.Int), b.(*big.Int))
+	case ratnumLevel:
+		return ops.rat(a.(*big.Rat), b.(*big.Rat))
+	case flonumLevel:
+		return ops.flo(a.(float64), b.(float64))
+	case bigfloatLevel:
+		return ops.bigf(th, a.(*big.Float), b.(*big.Float))
+	}
+	return ops.cpx(a.(complex128), b.(complex128))
+}
+
+func fixArith(a, b int, ops *numOps) interface{} {
+	if res, ok := ops.fix(a, b); ok {
+		return res
+	}
+	return ops.big(big.NewInt(int64(a)), big.NewInt(int64(b)))
+}
+
+// As arith, but for operations only defined on integers, which need no
+// thread.
+func intArith(a, b interface{}, ops *numOps) interface{} {
+	if numLevel(a) > bignumLevel {
+		TypeError("integer", a)
+	}
+	if numLevel(b) > bignumLevel {
+		TypeError("integer", b)
+	}
+	return arith(nil, a, b, ops)
+}
+
+func checkDivisor(zero bool) {
+	if zero {
+		Error("divide by zero")
+	}
+}
+
+var addOps = &numOps{
+	fix: func(a, b int) (interface{}, bool) {
+		c := a + b
+		return c, (c > a) == (b > 0)
+	},
+	big: func(a, b *big.Int) interface{} {
+		return normBig(new(big.Int).Add(a, b))
+	},
+	rat: func(a, b *big.Rat) interface{} {
+		return normRat(new(big.Rat).Add(a, b))
+	},
+	flo: func(a, b float64) interface{} { return a + b },
+	bigf: func(th *Thread, a, b *big.Float) interface{} {
+		return bigfloatOp(th, func(z *big.Float) { z.Add(a, b) })
+	},
+	cpx: func(a, b complex128) interface{} { return normComplex(a + b) },
+}
+
+var subOps = &numOps{
+	fix: func(a, b int) (interface{}, bool) {
+		c := a - b
+		return c, (c < a) == (b > 0)
+	},
+	big: func(a, b *big.Int) interface{} {
+		return normBig(new(big.Int).Sub(a, b))
+	},
+	rat: func(a, b *big.Rat) interface{} {
+		return normRat(new(big.Rat).Sub(a, b))
+	},
+	flo: func(a, b float64) interface{} { return a - b },
+	bigf: func(th *Thread, a, b *big.Float) interface{} {
+		return bigfloatOp(th, func(z *big.Float) { z.Sub(a, b) })
+	},
+	cpx: func(a, b complex128) interface{} { return normComplex(a - b) },
+}
+
+var mulOps = &numOps{
+	fix: func(a, b int) (interface{}, bool) {
+		if a == 0 || b == 0 {
+			return 0, true
+		}
+		if (a == -1 && b == math.MinInt) || (b == -1 && a == math.MinInt) {
+			return nil, false
+		}
+		c := a * b
+		return c, c/b == a
+	},
+	big: func(a, b *big.Int) interface{} {
+		return normBig(new(big.Int).Mul(a, b))
+	},
+	rat: func(a, b *big.Rat) interface{} {
+		return normRat(new(big.Rat).Mul(a, b))
+	},
+	flo: func(a, b float64) interface{} { return a * b },
+	bigf: func(th *Thread, a, b *big.Float) interface{} {
+		return bigfloatOp(th, func(z *big.Float) { z.Mul(a, b) })
+	},
+	cpx: func(a, b complex128) interface{} { return normComplex(a * b) },
+}
+
+// Dividing integers gives a ratnum if the result is not whole. Dividing a
+// flonum by zero gives an infinity or NaN.
+var divOps = &numOps{
+	fix: func(a, b int) (interface{}, bool) {
+		checkDivisor(b == 0)
+		if a == math.MinInt && b == -1 {
+			return nil, false
+		}
+		if a%b == 0 {
+			return a / b, true
+		}
+		return big.NewRat(int64(a), int64(b)), true
+	},
+	big: func(a, b *big.Int) interface{} {
+		checkDivisor(b.Sign() == 0)
+		return normRat(new(big.Rat).SetFrac(a, b))
+	},
+	rat: func(a, b *big.Rat) interface{} {
+		checkDivisor(b.Sign() == 0)
+		return normRat(new(big.Rat).Quo(a, b))
+	},
+	flo: func(a, b float64) interface{} { return a / b },
+	bigf: func(th *Thread, a, b *big.Float) interface{} {
+		return bigfloatOp(th, func(z *big.Float) { z.Quo(a, b) })
+	},
+	cpx: func(a, b complex128) interface{} { return normComplex(a / b) },
+}
+
+var quotientOps = &numOps{
+	fix: func(a, b int) (interface{}, bool) {
+		checkDivisor(b == 0)
+		if a == math.MinInt && b == -1 {
+			return nil, false
+		}
+		return a / b, true
+	},
+	big: func(a, b *big.Int) interface{} {
+		checkDivisor(b.Sign() == 0)
+		return normBig(new(big.Int).Quo(a, b))
+	},
+}
+
+var remainderOps = &numOps{
+	fix: func(a, b int) (interface{}, bool) {
+		checkDivisor(b == 0)
+		return a % b, true
+	},
+	big: func(a, b *big.Int) interface{} {
+		checkDivisor(b.Sign() == 0)
+		return normBig(new(big.Int).Rem(a, b))
+	},
+}
+
+// The result has the same sign as the divisor.
+var moduloOps = &numOps{
+	fix: func(a, b int) (interface{}, bool) {
+		checkDivisor(b == 0)
+		r := a % b
+		if r != 0 && (r < 0) != (b < 0) {
+			r += b
+		}
+		return r, true
+	},
+	big: func(a, b *big.Int) interface{} {
+		checkDivisor(b.Sign() == 0)
+		r := new(big.Int).Rem(a, b)
+		if r.Sign() != 0 && r.Sign() != b.Sign() {
+			r.Add(r, b)
+		}
+		return normBig(r)
+	},
+}
+
+/*
+	Comparison
+
+	Flonums and bigfloats are compared with exact numbers by converting them
+	to exact numbers, so that comparisons are transitive. Nothing is ordered with
+	respect to NaN.
+*/
+
+// Reports the sign of a - b, and whether a and b are ordered at all. Complex
+// numbers are not ordered, so are only compared with ==.
+func numCompare(a, b interface{}) (int, bool) {
+	if f, ok := a.(*big.Float); ok {
+		a = bigfloatToReal(f)
+	}
+	if f, ok := b.(*big.Float); ok {
+		b = bigfloatToReal(f)
+	}
+	if numLevel(a) == complexLevel {
+		TypeError("real number", a)
+	}
+	if numLevel(b) == complexLevel {
+		TypeError("real number", b)
+	}
+	fa, aflo := a.(float64)
+	fb, bflo := b.(float64)
+	switch {
+	case aflo && bflo:
+		switch {
+		case fa < fb:
+			return -1, true
+		case fa > fb:
+			return 1, true
+		}
+		return 0, fa == fb
+	case aflo:
+		if c, done, ok := compareSpecial(fa, b); done {
+			return c, ok
+		}
+		a = inexactToExact(fa)
+	case bflo:
+		if c, done, ok := compareSpecial(fb, a); done {
+			return -c, ok
+		}
+		b = inexactToExact(fb)
+	}
+	level := numLevel(a)
+	if l := numLevel(b); l > level {
+		level = l
+	}
+	// only exact numbers are left, which need no thread to convert
+	a, b = toLevel(nil, a, level), toLevel(nil, b, level)
+	switch level {
+	case fixnumLevel:
+		x, y := a.(int), b.(int)
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	case bignumLevel:
+		return a.(*big.Int).Cmp(b.(*big.Int)), true
+	}
+	return a.(*big.Rat).Cmp(b.(*big.Rat)), true
+}
+
+// Compares infinities and NaN with the exact number x.
+func compareSpecial(f float64, x interface{}) (c int, done, ok bool) {
+	numLevel(x)
+	switch {
+	case math.IsNaN(f):
+		return 0, true, false
+	case math.IsInf(f, 0):
+		return int(math.Copysign(1, f)), true, true
+	}
+	return 0, false, false
+}
+
+func compareChain(name string, args []interface{}, test func(c int) bool) interface{} {
+	switch len(args) {
+	case 0:
+		ArgumentError(Symbol(name), EMPTY_LIST)
+	case 1:
+		numLevel(args[0])
+	}
+	for i := 1; i < len(args); i++ {
+		if c, ok := numCompare(args[i-1], args[i]); !ok || !test(c) {
+			return false
+		}
+	}
+	return true
+}
+
+// Finds the argument that compares as want with all the others. If any of
+// the arguments are inexact so is the result.
+func extremum(th *Thread, name string, args []interface{}, want int) interface{} {
+	if len(args) == 0 {
+		ArgumentError(Symbol(name), EMPTY_LIST)
+	}
+	res := args[0]
+	level := fixnumLevel
+	for _, x := range args {
+		if l := numLevel(x); l > level {
+			level = l
+		}
+		c, ok := numCompare(x, res)
+		if !ok {
+			return math.NaN()
+		}
+		if c == want {
+			res = x
+		}
+	}
+	if level >= flonumLevel {
+		return toLevel(th, res, level)
+	}
+	return res
+}
+
+func complexEq(args []interface{}) interface{} {
+	for i := 1; i < len(args); i++ {
+		if toComplex(args[i-1]) != toComplex(args[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+	Number primitives
+*/
+
+func add(th *Thread, args ...interface{}) interface{} {
+	var res interface{} = 0
+	for _, x := range args {
+		res = arith(th, res, x, addOps)
+	}
+	return res
+}
+
+func mul(th *Thread, args ...interface{}) interface{} {
+	var res interface{} = 1
+	for _, x := range args {
+		res = arith(th, res, x, mulOps)
+	}
+	return res
+}
+
+// With one argument, (- x) negates x and (/ x) takes its reciprocal.
+func fold1(th *Thread, name string, unit interface{}, ops *numOps, args []interface{}) interface{} {
+	switch len(args) {
+	case 0:
+		ArgumentError(Symbol(name), EMPTY_LIST)
+	case 1:
+		return arith(th, unit, args[0], ops)
+	}
+	res := args[0]
+	for _, x := range args[1:] {
+		res = arith(th, res, x, ops)
+	}
+	return res
+}
+
+func sub(th *Thread, args ...interface{}) interface{} {
+	return fold1(th, "-", 0, subOps, args)
+}
+
+func div(th *Thread, args ...interface{}) interface{} {
+	return fold1(th, "/", 1, divOps, args)
+}
+
+func numEq(args ...interface{}) interface{} {
+	for _, x := range args {
+		if _, ok := x.(complex128); ok {
+			return complexEq(args)
+		}
+	}
+	return compareChain("=", args, func(c int) bool { return c == 0 })
+}
+
+func numLt(args ...interface{}) interface{} {
+	return compareChain("<", args, func(c int) bool { return c < 0 })
+}
+
+func numGt(args ...interface{}) interface{} {
+	return compareChain(">", args, func(c int) bool { return c > 0 })
+}
+
+func numLe(args ...interface{}) interface{} {
+	return compareChain("<=", args, func(c int) bool { return c <= 0 })
+}
+
+func numGe(args ...interface{}) interface{} {
+	return compareChain(">=", args, func(c int) bool { return c >= 0 })
+}
+
+func numMax(th *Thread, args ...interface{}) interface{} {
+	return extremum(th, "max", args, 1)
+}
+
+func numMin(th *Thread, args ...interface{}) interface{} {
+	return extremum(th, "min", args, -1)
+}
+
+func abs(x interface{}) interface{} {
+	switch n := x.(type) {
+	case int:
+		if n < 0 {
+			return fixArith(0, n, subOps)
+		}
+		return n
+	case *big.Int:
+		return new(big.Int).Abs(n)
+	case *big.Rat:
+		return new(big.Rat).Abs(n)
+	case float64:
+		return math.Abs(n)
+	case *big.Float:
+		return new(big.Float).Abs(n)
+	case complex128:
+		TypeError("real number", x)
+	}
+	TypeError("number", x)
+	panic("unreachable")
+}
+
+func quotient(a, b interface{}) interface{} {
+	return intArith(a, b, quotientOps)
+}
+
+func remainder(a, b interface{}) interface{} {
+	return intArith(a, b, remainderOps)
+}
+
+func modulo(a, b interface{}) interface{} {
+	return intArith(a, b, moduloOps)
+}
+
+func fixToFlo(x interface{}) interface{} {
+	return toFlonum(x)
+}
+
+// Inexact numbers are left as they are, so bigfloats keep their precision.
+func exactToInexact(x interface{}) interface{} {
+	if numLevel(x) >= flonumLevel {
+		return x
+	}
+	return toFlonum(x)
+}
+
+func inexactToExact(x interface{}) interface{} {
+	if b, ok := x.(*big.Float); ok {
+		if b.IsInf() {
+			TypeError("finite number", x)
+		}
+		return bigfloatToReal(b)
+	}
+	f, ok := x.(float64)
+	if !ok {
+		if numLevel(x) == complexLevel {
+			TypeError("real number", x)
+		}
+		return x
+	}
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		TypeError("finite number", x)
+	}
+	return normRat(new(big.Rat).SetFloat64(f))
+}
+
+// Applies f to x as an exact number. The result is as inexact as like.
+func exactly(th *Thread, x, like interface{}, f func(r *big.Rat) interface{}) interface{} {
+	res := f(toLevel(th, inexactToExact(x), ratnumLevel).(*big.Rat))
+	if l := numLevel(like); l >= flonumLevel {
+		return toLevel(th, res, l)
+	}
+	return res
+}
+
+func numerator(th *Thread, x interface{}) interface{} {
+	return exactly(th, x, x, func(r *big.Rat) interface{} {
+		return normBig(new(big.Int).Set(r.Num()))
+	})
+}
+
+func denominator(th *Thread, x interface{}) interface{} {
+	return exactly(th, x, x, func(r *big.Rat) interface{} {
+		return normBig(new(big.Int).Set(r.Denom()))
+	})
+}
+
+// The simplest rational number that differs from x by no more than y.
+func rationalize(th *Thread, x, y interface{}) interface{} {
+	like := x
+	if numLevel(y) > numLevel(x) {
+		like = y
+	}
+	return exactly(th, x, like, func(r *big.Rat) interface{} {
+		d := toLevel(th, inexactToExact(y), ratnumLevel).(*big.Rat)
+		d = new(big.Rat).Abs(d)
+		lo, hi := new(big.Rat).Sub(r, d), new(big.Rat).Add(r, d)
+		switch {
+		case lo.Sign() > 0:
+			return normRat(simplestBetween(lo, hi))
+		case hi.Sign() < 0:
+			res := simplestBetween(hi.Neg(hi), lo.Neg(lo))
+			return normRat(res.Neg(res))
+		}
+		return 0
+	})
+}
+
+// The rational with the smallest denominator in [lo, hi], where 0 < lo <= hi.
+func simplestBetween(lo, hi *big.Rat) *big.Rat {
+	fl := new(big.Int).Div(lo.Num(), lo.Denom())
+	if lo.IsInt() {
+		return new(big.Rat).SetInt(fl)
+	}
+	if fl.Cmp(new(big.Int).Div(hi.Num(), hi.Denom())) < 0 {
+		return new(big.Rat).SetInt(fl.Add(fl, big.NewInt(1)))
+	}
+	base := new(big.Rat).SetInt(fl)
+	rest := simplestBetween(
+		new(big.Rat).Inv(new(big.Rat).Sub(hi, base)),
+		new(big.Rat).Inv(new(big.Rat).Sub(lo, base)),
+	)
+	return base.Add(base, rest.Inv(rest))
+}
+
+func fixnumFunc(_a, _b interface{}, ops *numOps) interface{} {
+	a, ok := _a.(int)
+	if !ok {
+		TypeError("fixnum", _a)
+	}
+	b, ok := _b.(int)
+	if !ok {
+		TypeError("fixnum", _b)
+	}
+	return fixArith(a, b, ops)
+}
+
+func fixnumAdd(a, b interface{}) interface{} {
+	return fixnumFunc(a, b, addOps)
+}
+
+func fixnumSub(a, b interface{}) interface{} {
+	return fixnumFunc(a, b, subOps)
+}
+
+func fixnumMul(a, b interface{}) interface{} {
+	return fixnumFunc(a, b, mulOps)
+}
+
+func fixnumDiv(a, b interface{}) interface{} {
+	return fixnumFunc(a, b, divOps)
+}
+
+func flonumFunc(_a, _b interface{}, ops *numOps) interface{} {
+	a, ok := _a.(float64)
+	if !ok {
+		TypeError("flonum", _a)
+	}
+	b, ok := _b.(float64)
+	if !ok {
+		TypeError("flonum", _b)
+	}
+	return ops.flo(a, b)
+}
+
+func flonumAdd(a, b interface{}) interface{} {
+	return flonumFunc(a, b, addOps)
+}
+
+func flonumSub(a, b interface{}) interface{} {
+	return flonumFunc(a, b, subOps)
+}
+
+func flonumMul(a, b interface{}) interface{} {
+	return flonumFunc(a, b, mulOps)
+}
+
+func flonumDiv(a, b interface{}) interface{} {
+	return flonumFunc(a, b, divOps)
+}
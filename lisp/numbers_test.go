@@ -0,0 +1,147 @@
+package lisp
+
+import "testing"
+
+// Fixnums overflow into bignums, and bignums that fit shrink back.
+func TestNumericTower(t *testing.T) {
+	checkEval(t, []evalTest{
+		{"(+)", "0"},
+		{"(*)", "1"},
+		{"(- 10 1 2)", "7"},
+		{"(/ 12 2 3)", "2"},
+		{"(+ 9223372036854775807 1)", "9223372036854775808"},
+		{"(type-of (+ 9223372036854775807 1))", "bignum"},
+		{"(type-of (- (+ 9223372036854775807 1) 1))", "fixnum"},
+		{"(- -9223372036854775808 1)", "-9223372036854775809"},
+		{"(* 4294967296 4294967296)", "18446744073709551616"},
+		{"(* -1 -9223372036854775808)", "9223372036854775808"},
+		{"(/ -9223372036854775808 -1)", "9223372036854775808"},
+		{"(1+ 9223372036854775807)", "9223372036854775808"},
+		{"(type-of (/ 100000000000000000000 10000000000))", "fixnum"},
+		{"(type-of (+ 100000000000000000000 0.5))", "flonum"},
+		{"(quotient 100000000000000000000 3)", "33333333333333333333"},
+		{"(remainder -7 2)", "-1"},
+		{"(modulo -7 2)", "1"},
+		{"(modulo 7 -2)", "-1"},
+		{"(modulo -100000000000000000001 10)", "9"},
+		{"(/ 1 0)", "!error: divide by zero"},
+		{"(+ 1 'a)", "!type-error"},
+		{"(quotient 1.5 2)", "!type-error"},
+	})
+}
+
+// Flonums are read, written and computed with as float64s, infinities and
+// NaNs included.
+func TestFlonums(t *testing.T) {
+	checkEval(t, []evalTest{
+		{"(type-of 1.5)", "flonum"},
+		{"3.0", "3.0"},
+		{"(* 1.5 2)", "3.0"},
+		{"1e3", "1000.0"},
+		{"1.5e-7", "1.5e-07"},
+		{"-2.5E2", "-250.0"},
+		{"(flonum-add 0.1 0.2)", "0.30000000000000004"},
+		{"(/ 1.0 0)", "+inf.0"},
+		{"(- (/ 1.0 0))", "-inf.0"},
+		{"(* 0 +inf.0)", "+nan.0"},
+		{"(type-of +inf.0)", "flonum"},
+		{"-inf.0", "-inf.0"},
+		{"(symbol? (read-string \"+inf\"))", "#t"},
+	})
+}
+
+// Ratnums are kept in lowest terms and become fixnums when whole.
+func TestRationals(t *testing.T) {
+	checkEval(t, []evalTest{
+		{"(/ 1 3)", "1/3"},
+		{"(type-of (+ 1/3 2/3))", "fixnum"},
+		{"(* 2/3 3/4)", "1/2"},
+		{"-3/6", "-1/2"},
+		{"4/2", "2"},
+		{"(+ 1/2 0.5)", "1.0"},
+		{"(/ 100000000000000000000 3)", "100000000000000000000/3"},
+		{"(numerator 6/4)", "3"},
+		{"(denominator 0.5)", "2.0"},
+		{"(exact->inexact 1/4)", "0.25"},
+		{"(inexact->exact 0.1)", "3602879701896397/36028797018963968"},
+		{"(inexact->exact 2.0)", "2"},
+		{"(rationalize (inexact->exact 0.3) 1/10)", "1/3"},
+		{"(rationalize 0.3 1/10)", "0.3333333333333333"},
+		{"(quotient 1/2 2)", "!type-error"},
+		{"(read-string \"1/0\")", "!error"},
+	})
+}
+
+// Numbers of different types compare by value, exactly where possible.
+func TestNumericComparison(t *testing.T) {
+	checkEval(t, []evalTest{
+		{"(= 1 1.0)", "#t"},
+		{"(< 1 3 2)", "#f"},
+		{"(> 3 2.5 1/2)", "#t"},
+		{"(< 1/3 0.34)", "#t"},
+		{"(< 100000000000000000000 100000000000000000001)", "#t"},
+		{"(= 9007199254740993 9007199254740992.0)", "#f"},
+		{"(< 9007199254740992.0 9007199254740993)", "#t"},
+		{"(< -inf.0 -100000000000000000000)", "#t"},
+		{"(= +nan.0 +nan.0)", "#f"},
+		{"(> +nan.0 1)", "#f"},
+		{"(< 1 'a)", "!type-error"},
+		{"(max 1 2.0)", "2.0"},
+		{"(min 1 2.0)", "1.0"},
+		{"(abs -9223372036854775808)", "9223372036854775808"},
+		{"(equal? 100000000000000000000 100000000000000000000)", "#t"},
+		{"(equal? 1 1.0)", "#f"},
+		{"(eqv? 1/2 1/2)", "#t"},
+	})
+}
+
+// Rounding goes to even on ties, and results stay exact where they can.
+func TestMathLibrary(t *testing.T) {
+	checkEval(t, []evalTest{
+		{"(sqrt 16)", "4"},
+		{"(sqrt 1/4)", "1/2"},
+		{"(sqrt 100000000000000000000)", "10000000000"},
+		{"(sqrt 2)", "1.4142135623730951"},
+		{"(exact-integer-sqrt 17)", "(4 1)"},
+		{"(expt 2 100)", "1267650600228229401496703205376"},
+		{"(expt 2 -2)", "1/4"},
+		{"(expt 4 1/2)", "2.0"},
+		{"(expt 0 -1)", "!error: divide by zero"},
+		{"(< 399.99 (log (expt 10 400) 10) 400.01)", "#t"},
+		{"(round 5/2)", "2"},
+		{"(round 7/2)", "4"},
+		{"(round -5/2)", "-2"},
+		{"(round 2.5)", "2.0"},
+		{"(round 3.5)", "4.0"},
+		{"(floor -7/2)", "-4"},
+		{"(truncate -7/2)", "-3"},
+		{"(gcd -4 6)", "2"},
+		{"(lcm 3 0)", "0"},
+		{"(number->string -10 2)", `"-1010"`},
+		{`(string->number "ff" 16)`, "255"},
+		{`(string->number "+inf.0")`, "+inf.0"},
+		{`(string->number "1/0")`, "#f"},
+		{`(string->number "inf")`, "#f"},
+	})
+}
+
+// Bitwise operations act as if on two's complement integers of any length.
+func TestBitwise(t *testing.T) {
+	checkEval(t, []evalTest{
+		{"(bitwise-and 12 10)", "8"},
+		{"(bitwise-xor 12 10)", "6"},
+		{"(bitwise-and)", "-1"},
+		{"(bitwise-not 100000000000000000000)", "-100000000000000000001"},
+		{"(bitwise-and (- (expt 2 70)) (expt 2 70))", "1180591620717411303424"},
+		{"(arithmetic-shift 1 63)", "9223372036854775808"},
+		{"(type-of (arithmetic-shift -1 63))", "fixnum"},
+		{"(arithmetic-shift -5 -1)", "-3"},
+		{"(arithmetic-shift -5 -100)", "-1"},
+		{"(arithmetic-shift (expt 2 70) -70)", "1"},
+		{"(bit-count (- (expt 2 70) 1))", "70"},
+		{"(bit-set? 100 -1)", "#t"},
+		{"(bit-set? 3 (- (expt 2 70)))", "#f"},
+		{"(integer-length -256)", "8"},
+		{"(bitwise-and 1.5 1)", "!type-error"},
+	})
+}
@@ -2,12 +2,21 @@ package lisp
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
+	"math"
 	"math/big"
+	"math/cmplx"
 	"os"
+	"reflect"
+	"regexp"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
+	"unicode"
 
 	"github.com/bobappleyard/bwl/errors"
 )
@@ -16,24 +25,55 @@ import (
 func Primitives() Environment {
 	return WrapPrimitives(map[string]interface{}{
 		// equality
-		"==": eq,
+		"==":   eq,
+		"eqv?": eqv,
 		// syntax
-		"read":        Read,
-		"read-file":   ReadFile,
+		"read":        readOn,
+		"read-file":   readFileOn,
 		"read-string": readStr,
-		"write":       Write,
-		"display":     Display,
+		"write":       writeOn,
+		"display":     displayOn,
 		"macro":       newMacro,
 		// control
-		"go":                  spawn,
-		"load":                load,
-		"eval":                eval,
-		"apply":               apply,
-		"throw":               throw,
-		"catch":               catch,
-		"null-environment":    nullEnv,
-		"capture-environment": capEnv,
-		"start-process":       startProc,
+		"go":                   spawn,
+		"load":                 load,
+		"eval":                 eval,
+		"apply":                apply,
+		"throw":                throw,
+		"catch":                catch,
+		"call/ec":              callEC,
+		"null-environment":     nullEnv,
+		"capture-environment":  capEnv,
+		"start-process":        startProc,
+		"make-parameter":       makeParameter,
+		"call-with-parameters": callWithParameters,
+		// conditions
+		"raise":                   raise,
+		"raise-continuable":       raiseContinuable,
+		"with-exception-handler":  withExceptionHandler,
+		"guard-call":              guardCall,
+		"make-condition":          makeCondition,
+		"condition-kind":          conditionKind,
+		"condition-message":       conditionMessage,
+		"condition-field":         conditionField,
+		"condition-fields":        conditionFields,
+		"condition-is?":           conditionIs,
+		"register-condition-type": registerConditionType,
+		"call-with-restarts":      callWithRestarts,
+		"invoke-restart":          invokeRestart,
+		"find-restart":            findRestartPrim,
+		"compute-restarts":        computeRestarts,
+		"restart-name":            restartName,
+		// threads
+		"thread-join":     threadJoin,
+		"thread-done?":    threadDone,
+		"thread-name":     threadName,
+		"with-task-group": withTaskGroup,
+		"make-future":     makeFuture,
+		"touch":           touch,
+		"future-done?":    futureDone,
+		"pmap":            pmap,
+		"pfor-each":       pforEach,
 		// type system
 		"type-of":     typeOf,
 		"define-type": newCustom,
@@ -42,23 +82,149 @@ func Primitives() Environment {
 		"string->symbol": strToSym,
 		"gensym":         gensym,
 		// numbers
+		"+":              add,
+		"-":              sub,
+		"*":              mul,
+		"/":              div,
+		"=":              numEq,
+		"<":              numLt,
+		">":              numGt,
+		"<=":             numLe,
+		">=":             numGe,
+		"max":            numMax,
+		"min":            numMin,
+		"abs":            abs,
+		"quotient":       quotient,
+		"remainder":      remainder,
+		"modulo":         modulo,
+		"numerator":      numerator,
+		"denominator":    denominator,
+		"exact->inexact": exactToInexact,
+		"inexact->exact": inexactToExact,
+		"rationalize":    rationalize,
 		"fixnum->flonum": fixToFlo,
 		"fixnum-add":     fixnumAdd,
 		"fixnum-sub":     fixnumSub,
 		"fixnum-mul":     fixnumMul,
 		"fixnum-div":     fixnumDiv,
-		"quotient":       fixnumQuotient,
-		"remainder":      fixnumRemainder,
-		"modulo":         fixnumModulo,
 		"flonum-add":     flonumAdd,
 		"flonum-sub":     flonumSub,
 		"flonum-mul":     flonumMul,
 		"flonum-div":     flonumDiv,
+		// math
+		"sqrt":               sqrt,
+		"exact-integer-sqrt": exactIntegerSqrt,
+		"expt":               expt,
+		"exp":                exp,
+		"log":                log,
+		"sin":                flonumFn(math.Sin, cmplx.Sin),
+		"cos":                flonumFn(math.Cos, cmplx.Cos),
+		"tan":                flonumFn(math.Tan, cmplx.Tan),
+		"asin":               flonumFn(math.Asin, cmplx.Asin),
+		"acos":               flonumFn(math.Acos, cmplx.Acos),
+		"atan":               atan,
+		"floor":              floor,
+		"ceiling":            ceiling,
+		"round":              round,
+		"truncate":           truncate,
+		"gcd":                gcd,
+		"lcm":                lcm,
+		"number->string":     numberToString,
+		"string->number":     stringToNumber,
+		// bigfloats
+		"bigfloat":              bigfloat,
+		"bigfloat-precision":    bigfloatPrecision,
+		"current-precision":     currentPrecision,
+		"current-rounding-mode": currentRoundingMode,
+		// complex numbers
+		"make-rectangular": makeRectangular,
+		"make-polar":       makePolar,
+		"real-part":        realPart,
+		"imag-part":        imagPart,
+		"magnitude":        magnitude,
+		"angle":            angle,
+		// bitwise operations
+		"bitwise-and":      bitwiseAnd,
+		"bitwise-or":       bitwiseOr,
+		"bitwise-xor":      bitwiseXor,
+		"bitwise-not":      bitwiseNot,
+		"arithmetic-shift": arithmeticShift,
+		"bit-count":        bitCount,
+		"bit-set?":         bitSet,
+		"integer-length":   integerLength,
+		// random numbers
+		"make-random-source":  makeRandomSource,
+		"random-source-seed!": randomSourceSeed,
+		"random":              random,
+		"random-shuffle":      randomShuffle,
+		"crypto-random-bytes": cryptoRandomBytes,
+		// regular expressions
+		"regexp":             makeRegexp,
+		"regexp-match?":      regexpMatches,
+		"regexp-match":       regexpMatch,
+		"regexp-match-all":   regexpMatchAll,
+		"regexp-match-named": regexpMatchNamed,
+		"regexp-replace":     regexpReplace,
+		"regexp-split":       regexpSplit,
+		// characters
+		"char->integer":         charToInt,
+		"integer->char":         intToChar,
+		"char-upcase":           charFn(unicode.ToUpper),
+		"char-downcase":         charFn(unicode.ToLower),
+		"char-foldcase":         charFn(charFoldcase),
+		"char-alphabetic?":      charPred(unicode.IsLetter),
+		"char-numeric?":         charPred(unicode.IsDigit),
+		"char-whitespace?":      charPred(unicode.IsSpace),
+		"char-upper-case?":      charPred(unicode.IsUpper),
+		"char-lower-case?":      charPred(unicode.IsLower),
+		"char-punctuation?":     charPred(unicode.IsPunct),
+		"char-symbolic?":        charPred(unicode.IsSymbol),
+		"char-control?":         charPred(unicode.IsControl),
+		"char-general-category": charGeneralCategory,
+		"digit-value":           digitValue,
+		"char=?":                charEq,
+		"char<?":                charLt,
+		"char>?":                charGt,
+		"char<=?":               charLe,
+		"char>=?":               charGe,
+		"char-ci=?":             charCiEq,
+		"char-ci<?":             charCiLt,
+		"char-ci>?":             charCiGt,
+		"char-ci<=?":            charCiLe,
+		"char-ci>=?":            charCiGe,
 		// strings
 		"string-split":   stringSplit,
 		"string-join":    stringJoin,
 		"string->vector": strToVec,
 		"object->string": objToStr,
+		// string operations
+		"string-length":     stringLength,
+		"string-ref":        stringRef,
+		"substring":         substring,
+		"string-index":      stringIndex,
+		"string-contains":   stringContains,
+		"string-prefix?":    stringPrefix,
+		"string-suffix?":    stringSuffix,
+		"string-upcase":     stringUpcase,
+		"string-downcase":   stringDowncase,
+		"string-foldcase":   stringFoldcase,
+		"string-trim":       stringTrim,
+		"string-trim-left":  stringTrimLeft,
+		"string-trim-right": stringTrimRight,
+		"string-pad":        stringPad,
+		"string-pad-right":  stringPadRight,
+		"string-replace":    stringReplace,
+		"string-reverse":    stringReverse,
+		"string=?":          stringEq,
+		"string<?":          stringLt,
+		"string>?":          stringGt,
+		"string<=?":         stringLe,
+		"string>=?":         stringGe,
+		"string-ci=?":       stringCiEq,
+		"string-ci<?":       stringCiLt,
+		"string-ci>?":       stringCiGt,
+		"string-ci<=?":      stringCiLe,
+		"string-ci>=?":      stringCiGe,
 		// pairs
 		"cons":         Cons,
 		"car":          Car,
@@ -73,18 +239,69 @@ func Primitives() Environment {
 		"vector->list":   vecToLs,
 		"vector->string": vecToStr,
 		// ports
-		"open-file":    openFile,
-		"read-char":    readChar,
-		"read-byte":    readByte,
-		"eof-object?":  isEof,
-		"write-string": writeString,
-		"write-byte":   writeByte,
-		"flush":        flush,
-		"close":        closePort,
+		"open-file":           openFile,
+		"read-char":           readChar,
+		"read-byte":           readByte,
+		"eof-object?":         isEof,
+		"write-string":        writeString,
+		"write-byte":          writeByte,
+		"flush":               flush,
+		"close":               closePort,
+		"call-with-port-lock": callWithPortLock,
 		// channels
-		"make-channel":    makeChannel,
-		"channel-send":    send,
-		"channel-receive": receive,
+		"make-channel":        makeChannel,
+		"channel-send":        send,
+		"channel-receive":     receive,
+		"channel-try-send":    trySend,
+		"channel-try-receive": tryReceive,
+		"channel-close":       closeChannel,
+		"channel-select":      channelSelect,
+		// time
+		"current-time-ms": currentTimeMs,
+		"sleep":           sleep,
+		"after":           after,
+		"make-ticker":     makeTicker,
+		"ticker-channel":  tickerChannel,
+		"ticker-stop":     tickerStop,
+		// actors
+		"spawn-actor":       spawnActor,
+		"self":              actorSelf,
+		"send":              actorSend,
+		"actor-receive":     actorReceive,
+		"monitor":           actorMonitor,
+		"link":              actorLink,
+		"actor-alive?":      actorAlive,
+		"pattern-match":     patternMatch,
+		"pattern-variables": patternVars,
+		// atoms and refs
+		"atom":                atom,
+		"deref":               deref,
+		"reset!":              reset,
+		"swap!":               swap,
+		"compare-and-set!":    compareAndSet,
+		"ref":                 ref,
+		"ref-set!":            refSet,
+		"call-in-transaction": callInTransaction,
+		// synchronisation
+		"make-mutex":               makeMutex,
+		"mutex-lock":               mutexLock,
+		"mutex-try-lock":           mutexTryLock,
+		"mutex-unlock":             mutexUnlock,
+		"make-rw-mutex":            makeRWMutex,
+		"rw-mutex-lock":            rwMutexLock,
+		"rw-mutex-unlock":          rwMutexUnlock,
+		"rw-mutex-read-lock":       rwMutexReadLock,
+		"rw-mutex-read-unlock":     rwMutexReadUnlock,
+		"make-wait-group":          makeWaitGroup,
+		"wait-group-add":           waitGroupAdd,
+		"wait-group-done":          waitGroupDone,
+		"wait-group-wait":          waitGroupWait,
+		"once":                     once,
+		"make-atomic":              makeAtomic,
+		"atomic-ref":               atomicRef,
+		"atomic-set!":              atomicSet,
+		"atomic-swap!":             atomicSwap,
+		"atomic-compare-and-swap!": atomicCompareAndSwap,
 	})
 }
 
@@ -101,6 +318,16 @@ func eq(a, b interface{}) interface{} {
 	return res
 }
 
+// As ==, but numbers are compared by value, if they are equally exact.
+// Complex numbers are values already.
+func eqv(a, b interface{}) interface{} {
+	if isNumber(a) && isNumber(b) && numLevel(a) == numLevel(b) && numLevel(a) != complexLevel {
+		c, ok := numCompare(a, b)
+		return ok && c == 0
+	}
+	return eq(a, b)
+}
+
 /*
 	Syntax
 */
@@ -125,16 +352,15 @@ func newMacro(m interface{}) interface{} {
 	Control
 */
 
-func spawn(f interface{}) interface{} {
+func spawn(th *Thread, f, args interface{}) interface{} {
 	fn, ok := f.(Function)
 	if !ok {
 		TypeError("function", f)
 	}
-	go fn.Apply(EMPTY_LIST)
-	return nil
+	return th.spawn(fn, args)
 }
 
-func load(path, env interface{}) interface{} {
+func load(th *Thread, path, env interface{}) interface{} {
 	ctx, ok := env.(*Scope)
 	if !ok {
 		TypeError("environment", env)
@@ -143,24 +369,24 @@ func load(path, env interface{}) interface{} {
 	if !ok {
 		TypeError("string", path)
 	}
-	ctx.Load(p)
+	ctx.load(th, p)
 	return nil
 }
 
-func eval(expr, env interface{}) interface{} {
+func eval(th *Thread, expr, env interface{}) interface{} {
 	ctx, ok := env.(*Scope)
 	if !ok {
 		TypeError("environment", env)
 	}
-	return ctx.Eval(expr)
+	return ctx.eval(th, expr)
 }
 
-func apply(f, args interface{}) interface{} {
+func apply(th *Thread, f, args interface{}) interface{} {
 	fn, ok := f.(Function)
 	if !ok {
 		TypeError("function", f)
 	}
-	return fn.Apply(args)
+	return applyOn(th, fn, args)
 }
 
 func throw(kind, msg interface{}) interface{} {
@@ -172,7 +398,7 @@ func throw(kind, msg interface{}) interface{} {
 	panic("unreachable")
 }
 
-func catch(thk, hnd interface{}) interface{} {
+func catch(th *Thread, thk, hnd interface{}) interface{} {
 	t, ok := thk.(Function)
 	if !ok {
 		TypeError("function", t)
@@ -182,13 +408,42 @@ func catch(thk, hnd interface{}) interface{} {
 		TypeError("function", h)
 	}
 	var res interface{}
-	errors.Catch(
-		func() { res = Call(t) },
-		func(err interface{}) {
-			e := WrapError(err).(*errorStruct)
-			res = Call(h, e.kind, e.msg)
-		},
-	)
+	if e, failed := catchErrors(th, func() { res = callOn(th, t) }); failed {
+		res = callOn(th, h, e.kind, e.msg)
+	}
+	return res
+}
+
+type exitCall struct {
+	point *int
+	v     interface{}
+}
+
+func (*exitCall) escape() {}
+
+// Calls f with an escape procedure that returns its argument from callEC.
+func callEC(th *Thread, f interface{}) interface{} {
+	fn, ok := f.(Function)
+	if !ok {
+		TypeError("function", f)
+	}
+	point := new(int)
+	k := Primitive(func(args interface{}) interface{} {
+		panic(&exitCall{point, Car(args)})
+	})
+	var res interface{}
+	func() {
+		defer func() {
+			if err := recover(); err != nil {
+				if e, ok := err.(*exitCall); ok && e.point == point {
+					res = e.v
+					return
+				}
+				panic(err)
+			}
+		}()
+		res = callOn(th, fn, k)
+	}()
 	return res
 }
 
@@ -235,6 +490,199 @@ func startProc(path, args interface{}) interface{} {
 	return Cons(NewOutput(inw), NewInput(outr))
 }
 
+func makeParameter(th *Thread, val, conv interface{}) interface{} {
+	if conv == false {
+		return newParameter(th, val, nil)
+	}
+	c, ok := conv.(Function)
+	if !ok {
+		TypeError("function", conv)
+	}
+	return newParameter(th, val, c)
+}
+
+func callWithParameters(th *Thread, params, vals, thk interface{}) interface{} {
+	t, ok := thk.(Function)
+	if !ok {
+		TypeError("function", thk)
+	}
+	ps := lsToVec(params).(Vector)
+	vs := lsToVec(vals).(Vector)
+	if len(ps) != len(vs) {
+		Error("parameters and values differ in number")
+	}
+	pv := make([]*Parameter, len(ps))
+	for i, x := range ps {
+		p, ok := x.(*Parameter)
+		if !ok {
+			TypeError("parameter", x)
+		}
+		pv[i] = p
+	}
+	return Parameterize(th, pv, vs, t)
+}
+
+/*
+	Threads
+*/
+
+func threadJoin(thread interface{}) interface{} {
+	t, ok := thread.(*Thread)
+	if !ok {
+		TypeError("thread", thread)
+	}
+	return t.Join()
+}
+
+func threadDone(thread interface{}) interface{} {
+	t, ok := thread.(*Thread)
+	if !ok {
+		TypeError("thread", thread)
+	}
+	return t.Done()
+}
+
+func threadName(thread interface{}) interface{} {
+	t, ok := thread.(*Thread)
+	if !ok {
+		TypeError("thread", thread)
+	}
+	return t.Name()
+}
+
+func withTaskGroup(th *Thread, f interface{}) interface{} {
+	fn, ok := f.(Function)
+	if !ok {
+		TypeError("function", f)
+	}
+	g := newTaskGroup(th.ctx)
+	var start Function
+	start = threadPrimitive(func(th *Thread, args interface{}) interface{} {
+		if args == EMPTY_LIST {
+			ArgumentError(start, args)
+		}
+		f, ok := Car(args).(Function)
+		if !ok {
+			TypeError("function", Car(args))
+		}
+		return g.Spawn(th, f, Cdr(args))
+	})
+	// the body can be cancelled along with the rest of the group
+	var res interface{}
+	var esc escape
+	ctx := th.ctx
+	th.ctx = g.ctx
+	defer func() { th.ctx = ctx }()
+	errors.Catch(
+		func() { res = callOn(th, fn, start) },
+		func(err interface{}) {
+			// escapes leave the body as returning would, once the group is
+			// done
+			if e, ok := err.(escape); ok {
+				esc = e
+				return
+			}
+			g.fail(err)
+		},
+	)
+	g.Close()
+	if esc != nil {
+		panic(esc)
+	}
+	return res
+}
+
+func makeFuture(th *Thread, thk interface{}) interface{} {
+	t, ok := thk.(Function)
+	if !ok {
+		TypeError("function", thk)
+	}
+	return NewFuture(th, t)
+}
+
+func touch(future interface{}) interface{} {
+	f, ok := future.(*Future)
+	if !ok {
+		TypeError("future", future)
+	}
+	return f.Touch()
+}
+
+func futureDone(future interface{}) interface{} {
+	f, ok := future.(*Future)
+	if !ok {
+		TypeError("future", future)
+	}
+	return f.Done()
+}
+
+// Calls body for every index up to n, spread across as many threads as Go
+// will run at once. Stops at the first error, which is raised once every
+// thread has finished.
+func parallel(th *Thread, n int, body func(th *Thread, i int)) {
+	workers := runtime.GOMAXPROCS(0)
+	if workers > n {
+		workers = n
+	}
+	g := newTaskGroup(th.ctx)
+	next := int64(-1)
+	work := threadPrimitive(func(th *Thread, _ interface{}) interface{} {
+		for g.ctx.Err() == nil {
+			i := int(atomic.AddInt64(&next, 1))
+			if i >= n {
+				break
+			}
+			body(th, i)
+		}
+		return nil
+	})
+	for i := 0; i < workers; i++ {
+		g.Spawn(th, work, EMPTY_LIST)
+	}
+	g.Close()
+}
+
+func pmap(th *Thread, f, seq interface{}) interface{} {
+	fn, ok := f.(Function)
+	if !ok {
+		TypeError("function", f)
+	}
+	xs, isVec := seq.(Vector)
+	if !isVec {
+		xs = lsToVec(seq).(Vector)
+	}
+	res := make(Vector, len(xs))
+	parallel(th, len(xs), func(th *Thread, i int) {
+		res[i] = callOn(th, fn, xs[i])
+	})
+	if isVec {
+		return res
+	}
+	return vecToLs(res)
+}
+
+func pforEach(th *Thread, f, seq interface{}) interface{} {
+	fn, ok := f.(Function)
+	if !ok {
+		TypeError("function", f)
+	}
+	xs, isVec := seq.(Vector)
+	if !isVec {
+		xs = lsToVec(seq).(Vector)
+	}
+	parallel(th, len(xs), func(th *Thread, i int) {
+		callOn(th, fn, xs[i])
+	})
+	return nil
+}
+
+// Raised in a thread blocked on a channel when its task group is cancelled.
+func checkCancelled(ctx context.Context) {
+	if ctx.Err() != nil {
+		Throw(Symbol("cancelled"), "thread cancelled")
+	}
+}
+
 /*
 	Type system
 */
@@ -246,7 +694,7 @@ func typeOf(x interface{}) interface{} {
 		s = "boolean"
 	case int:
 		s = "fixnum"
-	case float32:
+	case float64:
 		s = "flonum"
 	case string:
 		s = "string"
@@ -258,6 +706,10 @@ func typeOf(x interface{}) interface{} {
 		s = "vector"
 	case *macro:
 		s = "macro"
+	case *Once:
+		s = "once"
+	case *Parameter:
+		s = "parameter"
 	case Function:
 		s = "function"
 	case *InputPort:
@@ -268,8 +720,44 @@ func typeOf(x interface{}) interface{} {
 		return x.(*Custom).Name()
 	case *big.Int:
 		s = "bignum"
+	case *big.Rat:
+		s = "ratnum"
+	case *big.Float:
+		s = "bigfloat"
+	case complex128:
+		s = "complex"
+	case rune:
+		s = "char"
+	case *regexp.Regexp:
+		s = "regexp"
+	case *RandomSource:
+		s = "random-source"
 	case chan interface{}:
 		s = "channel"
+	case *Thread:
+		s = "thread"
+	case *Future:
+		s = "future"
+	case *Actor:
+		s = "actor"
+	case *Ticker:
+		s = "ticker"
+	case *Atom:
+		s = "atom"
+	case *Ref:
+		s = "ref"
+	case *Mutex:
+		s = "mutex"
+	case *RWMutex:
+		s = "rw-mutex"
+	case *WaitGroup:
+		s = "wait-group"
+	case *Atomic:
+		s = "atomic"
+	case *errorStruct:
+		s = "condition"
+	case *Restart:
+		s = "restart"
 	}
 	if x == nil {
 		s = "void"
@@ -277,7 +765,7 @@ func typeOf(x interface{}) interface{} {
 	return Symbol(s)
 }
 
-func newCustom(name, fn interface{}) interface{} {
+func newCustom(th *Thread, name, fn interface{}) interface{} {
 	n, ok := name.(Symbol)
 	if !ok {
 		TypeError("symbol", name)
@@ -304,7 +792,7 @@ func newCustom(name, fn interface{}) interface{} {
 		c.Set(v)
 		return nil
 	})
-	Call(f, wrap, unwrap, set)
+	callOn(th, f, wrap, unwrap, set)
 	return nil
 }
 
@@ -344,121 +832,6 @@ func gensym() interface{} {
 	return <-gensyms
 }
 
-/*
-	Numbers
-*/
-
-func fixToFlo(_x interface{}) interface{} {
-	switch x := _x.(type) {
-	case int:
-		return float32(x)
-	case float32:
-		return x
-	}
-	TypeError("number", _x)
-	panic("unreachable")
-}
-
-func fixnumFunc(_a, _b interface{}, f func(a, b int) interface{}) interface{} {
-	a, ok := _a.(int)
-	if !ok {
-		TypeError("fixnum", _a)
-	}
-	b, ok := _b.(int)
-	if !ok {
-		TypeError("fixnum", _b)
-	}
-	return f(a, b)
-}
-
-func fixnumAdd(_a, _b interface{}) interface{} {
-	return fixnumFunc(_a, _b, func(a, b int) interface{} { return a + b })
-}
-
-func fixnumSub(_a, _b interface{}) interface{} {
-	return fixnumFunc(_a, _b, func(a, b int) interface{} { return a - b })
-}
-
-func fixnumMul(_a, _b interface{}) interface{} {
-	return fixnumFunc(_a, _b, func(a, b int) interface{} { return a * b })
-}
-
-func fixnumDiv(_a, _b interface{}) interface{} {
-	return fixnumFunc(_a, _b, func(a, b int) interface{} {
-		if b == 0 {
-			Error("divide by zero")
-		}
-		if a%b == 0 {
-			return a / b
-		}
-		return float32(a) / float32(b)
-	})
-}
-
-func fixnumQuotient(_a, _b interface{}) interface{} {
-	return fixnumFunc(_a, _b, func(a, b int) interface{} {
-		if b == 0 {
-			Error("divide by zero")
-		}
-		return a / b
-	})
-}
-
-func fixnumRemainder(_a, _b interface{}) interface{} {
-	return fixnumFunc(_a, _b, func(a, b int) interface{} {
-		if b == 0 {
-			Error("divide by zero")
-		}
-		return a % b
-	})
-}
-
-func fixnumModulo(_a, _b interface{}) interface{} {
-	return fixnumFunc(_a, _b, func(a, b int) interface{} {
-		if b == 0 {
-			Error("divide by zero")
-		}
-		r := a % b
-		if !(r == 0 || (a > 0 && b > 0) || (a < 0 && b < 0)) {
-			r += b
-		}
-		return r
-	})
-}
-
-func flonumFunc(_a, _b interface{}, f func(a, b float32) interface{}) interface{} {
-	a, ok := _a.(float32)
-	if !ok {
-		TypeError("flonum", _a)
-	}
-	b, ok := _b.(float32)
-	if !ok {
-		TypeError("flonum", _b)
-	}
-	return f(a, b)
-}
-
-func flonumAdd(_a, _b interface{}) interface{} {
-	return flonumFunc(_a, _b, func(a, b float32) interface{} { return a + b })
-}
-
-func flonumSub(_a, _b interface{}) interface{} {
-	return flonumFunc(_a, _b, func(a, b float32) interface{} { return a - b })
-}
-
-func flonumMul(_a, _b interface{}) interface{} {
-	return flonumFunc(_a, _b, func(a, b float32) interface{} { return a * b })
-}
-
-func flonumDiv(_a, _b interface{}) interface{} {
-	return flonumFunc(_a, _b, func(a, b float32) interface{} {
-		if b == 0 {
-			Error("divide by zero")
-		}
-		return a / b
-	})
-}
-
 /*
 	Strings
 */
@@ -591,11 +964,7 @@ func lsToVec(lst interface{}) interface{} {
 	}
 	res := make(Vector, l)
 	for i := 0; lst != EMPTY_LIST; i, lst = i+1, Cdr(lst) {
-		a := Car(lst)
-		if Failed(a) {
-			return a
-		}
-		res[i] = a
+		res[i] = Car(lst)
 	}
 	return res
 }
@@ -621,7 +990,7 @@ func vecToStr(vec interface{}) interface{} {
 	for i, c := range cs {
 		r, ok := c.(rune)
 		if !ok {
-			TypeError("vector of fixnums", vec)
+			TypeError("vector of chars", vec)
 		}
 		res[i] = r
 	}
@@ -664,27 +1033,27 @@ func openFile(path, mode interface{}) interface{} {
 	return wrap(f)
 }
 
-func readChar(port interface{}) interface{} {
+func readChar(th *Thread, port interface{}) interface{} {
 	p, ok := port.(*InputPort)
 	if !ok {
 		TypeError("input-port", port)
 	}
-	return p.ReadChar()
+	return p.readCharOn(th)
 }
 
-func readByte(port interface{}) interface{} {
+func readByte(th *Thread, port interface{}) interface{} {
 	p, ok := port.(*InputPort)
 	if !ok {
 		TypeError("input-port", port)
 	}
-	return p.ReadByte()
+	return p.readByteOn(th)
 }
 
 func isEof(x interface{}) interface{} {
 	return x == EOF_OBJECT
 }
 
-func writeString(port, str interface{}) interface{} {
+func writeString(th *Thread, port, str interface{}) interface{} {
 	p, ok := port.(*OutputPort)
 	if !ok {
 		TypeError("output-port", port)
@@ -693,11 +1062,11 @@ func writeString(port, str interface{}) interface{} {
 	if !ok {
 		TypeError("string", str)
 	}
-	p.WriteString(s)
+	p.writeStringOn(th, s)
 	return nil
 }
 
-func writeByte(port, bte interface{}) interface{} {
+func writeByte(th *Thread, port, bte interface{}) interface{} {
 	p, ok := port.(*OutputPort)
 	if !ok {
 		TypeError("output-port", port)
@@ -706,52 +1075,396 @@ func writeByte(port, bte interface{}) interface{} {
 	if !ok {
 		TypeError("fixnum", bte)
 	}
-	p.WriteByte(byte(b))
+	p.writeByteOn(th, byte(b))
 	return nil
 }
 
-func flush(port interface{}) interface{} {
+func flush(th *Thread, port interface{}) interface{} {
 	p, ok := port.(*OutputPort)
 	if !ok {
 		TypeError("output-port", port)
 	}
-	p.Flush()
+	p.flushOn(th)
 	return nil
 }
 
-func closePort(port interface{}) interface{} {
+func closePort(th *Thread, port interface{}) interface{} {
 	switch p := port.(type) {
 	case *InputPort:
-		p.Close()
+		p.closeOn(th)
 	case *OutputPort:
-		p.Close()
+		p.closeOn(th)
 	default:
 		TypeError("port", port)
 	}
 	return nil
 }
 
+func callWithPortLock(th *Thread, port, thk interface{}) interface{} {
+	t, ok := thk.(Function)
+	if !ok {
+		TypeError("function", thk)
+	}
+	switch p := port.(type) {
+	case *InputPort:
+		p.lockOn(th)
+		defer p.Unlock()
+	case *OutputPort:
+		p.lockOn(th)
+		defer p.Unlock()
+	default:
+		TypeError("port", port)
+	}
+	return callOn(th, t)
+}
+
 /*
 	Channels
 */
 
-func makeChannel() interface{} {
-	return make(chan interface{})
+func makeChannel(size interface{}) interface{} {
+	n, ok := size.(int)
+	if !ok {
+		TypeError("fixnum", size)
+	}
+	if n < 0 {
+		Error(fmt.Sprintf("invalid channel size (%v)", n))
+	}
+	return make(chan interface{}, n)
 }
 
-func send(ch, v interface{}) interface{} {
-	channel, ok := ch.(chan interface{})
+func toChannel(ch interface{}) chan interface{} {
+	res, ok := ch.(chan interface{})
 	if !ok {
 		TypeError("channel", ch)
 	}
-	channel <- v
+	return res
+}
+
+// Go panics when sending on or closing a closed channel. Turn those into
+// ordinary errors.
+func channelOp(f func()) {
+	defer func() {
+		if err := recover(); err != nil {
+			if e, ok := err.(runtime.Error); ok {
+				Error(e.Error())
+			}
+			panic(err)
+		}
+	}()
+	f()
+}
+
+func received(v interface{}, ok bool) interface{} {
+	if !ok {
+		return EOF_OBJECT
+	}
+	return v
+}
+
+func send(th *Thread, ch, v interface{}) interface{} {
+	channel := toChannel(ch)
+	ctx := th.ctx
+	channelOp(func() {
+		select {
+		case channel <- v:
+		case <-ctx.Done():
+			checkCancelled(ctx)
+		}
+	})
+	return nil
+}
+
+func receive(th *Thread, ch interface{}) interface{} {
+	channel := toChannel(ch)
+	ctx := th.ctx
+	select {
+	case res, ok := <-channel:
+		return received(res, ok)
+	case <-ctx.Done():
+		checkCancelled(ctx)
+	}
+	panic("unreachable")
+}
+
+func trySend(ch, v interface{}) interface{} {
+	channel := toChannel(ch)
+	res := false
+	channelOp(func() {
+		select {
+		case channel <- v:
+			res = true
+		default:
+		}
+	})
+	return res
+}
+
+func tryReceive(ch, dflt interface{}) interface{} {
+	channel := toChannel(ch)
+	select {
+	case res, ok := <-channel:
+		return received(res, ok)
+	default:
+	}
+	return dflt
+}
+
+func closeChannel(ch interface{}) interface{} {
+	channel := toChannel(ch)
+	channelOp(func() { close(channel) })
 	return nil
 }
 
-func receive(ch interface{}) interface{} {
-	channel, ok := ch.(chan interface{})
+// Waits on several channel operations at once. Each case is a list holding a
+// channel, and for sends the value to send. Returns a pair of the index of
+// the case that fired and the value received, or a pair headed by the symbol
+// timeout or default if the timeout (in milliseconds) expired or dflt is
+// true and nothing was ready.
+func channelSelect(th *Thread, cases, timeout, dflt interface{}) interface{} {
+	var sc []reflect.SelectCase
+	for cur := cases; cur != EMPTY_LIST; cur = Cdr(cur) {
+		c := Car(cur)
+		channel := reflect.ValueOf(toChannel(Car(c)))
+		if Cdr(c) == EMPTY_LIST {
+			sc = append(sc, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: channel})
+		} else {
+			v := Car(Cdr(c))
+			sc = append(sc, reflect.SelectCase{
+				Dir:  reflect.SelectSend,
+				Chan: channel,
+				Send: reflect.ValueOf(&v).Elem(),
+			})
+		}
+	}
+	n := len(sc)
+	ctx := th.ctx
+	sc = append(sc, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())})
+	if timeout != false {
+		ms, ok := timeout.(int)
+		if !ok {
+			TypeError("fixnum", timeout)
+		}
+		after := time.After(time.Duration(ms) * time.Millisecond)
+		sc = append(sc, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(after)})
+	}
+	if True(dflt) {
+		sc = append(sc, reflect.SelectCase{Dir: reflect.SelectDefault})
+	}
+	var (
+		i  int
+		v  reflect.Value
+		ok bool
+	)
+	channelOp(func() { i, v, ok = reflect.Select(sc) })
+	switch {
+	case i < n:
+		if sc[i].Dir == reflect.SelectSend {
+			return Cons(i, nil)
+		}
+		if !ok {
+			return Cons(i, EOF_OBJECT)
+		}
+		return Cons(i, v.Interface())
+	case i == n:
+		checkCancelled(ctx)
+	case sc[i].Dir == reflect.SelectDefault:
+		return Cons(Symbol("default"), nil)
+	}
+	return Cons(Symbol("timeout"), nil)
+}
+
+/*
+	Time
+*/
+
+func toDuration(ms interface{}) time.Duration {
+	n, ok := ms.(int)
 	if !ok {
-		TypeError("channel", ch)
+		TypeError("fixnum", ms)
+	}
+	return time.Duration(n) * time.Millisecond
+}
+
+func currentTimeMs() interface{} {
+	return timeToMs(time.Now())
+}
+
+// Like channel operations, sleeping is interrupted by cancellation.
+func sleep(th *Thread, ms interface{}) interface{} {
+	d := toDuration(ms)
+	ctx := th.ctx
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+		checkCancelled(ctx)
+	}
+	return nil
+}
+
+// Returns a channel that receives the time once ms milliseconds have passed.
+func after(ms interface{}) interface{} {
+	d := toDuration(ms)
+	res := make(chan interface{}, 1)
+	time.AfterFunc(d, func() {
+		res <- timeToMs(time.Now())
+	})
+	return res
+}
+
+func makeTicker(ms interface{}) interface{} {
+	d := toDuration(ms)
+	if d <= 0 {
+		Error(fmt.Sprintf("invalid ticker interval (%v)", ms))
 	}
-	return <-channel
+	return NewTicker(d)
+}
+
+func toTicker(ticker interface{}) *Ticker {
+	t, ok := ticker.(*Ticker)
+	if !ok {
+		TypeError("ticker", ticker)
+	}
+	return t
+}
+
+func tickerChannel(ticker interface{}) interface{} {
+	return toTicker(ticker).Chan()
+}
+
+func tickerStop(ticker interface{}) interface{} {
+	toTicker(ticker).Stop()
+	return nil
+}
+
+/*
+	Synchronisation
+*/
+
+func makeMutex() interface{} {
+	return new(Mutex)
+}
+
+func toMutex(mutex interface{}) *Mutex {
+	m, ok := mutex.(*Mutex)
+	if !ok {
+		TypeError("mutex", mutex)
+	}
+	return m
+}
+
+func mutexLock(mutex interface{}) interface{} {
+	toMutex(mutex).Lock()
+	return nil
+}
+
+func mutexTryLock(mutex interface{}) interface{} {
+	return toMutex(mutex).TryLock()
+}
+
+func mutexUnlock(mutex interface{}) interface{} {
+	toMutex(mutex).Unlock()
+	return nil
+}
+
+func makeRWMutex() interface{} {
+	return new(RWMutex)
+}
+
+func toRWMutex(mutex interface{}) *RWMutex {
+	m, ok := mutex.(*RWMutex)
+	if !ok {
+		TypeError("rw-mutex", mutex)
+	}
+	return m
+}
+
+func rwMutexLock(mutex interface{}) interface{} {
+	toRWMutex(mutex).Lock()
+	return nil
+}
+
+func rwMutexUnlock(mutex interface{}) interface{} {
+	toRWMutex(mutex).Unlock()
+	return nil
+}
+
+func rwMutexReadLock(mutex interface{}) interface{} {
+	toRWMutex(mutex).RLock()
+	return nil
+}
+
+func rwMutexReadUnlock(mutex interface{}) interface{} {
+	toRWMutex(mutex).RUnlock()
+	return nil
+}
+
+func makeWaitGroup() interface{} {
+	return new(WaitGroup)
+}
+
+func toWaitGroup(wg interface{}) *WaitGroup {
+	w, ok := wg.(*WaitGroup)
+	if !ok {
+		TypeError("wait-group", wg)
+	}
+	return w
+}
+
+func waitGroupAdd(wg, delta interface{}) interface{} {
+	n, ok := delta.(int)
+	if !ok {
+		TypeError("fixnum", delta)
+	}
+	toWaitGroup(wg).Add(n)
+	return nil
+}
+
+func waitGroupDone(wg interface{}) interface{} {
+	toWaitGroup(wg).Add(-1)
+	return nil
+}
+
+func waitGroupWait(wg interface{}) interface{} {
+	toWaitGroup(wg).Wait()
+	return nil
+}
+
+func once(f interface{}) interface{} {
+	fn, ok := f.(Function)
+	if !ok {
+		TypeError("function", f)
+	}
+	return NewOnce(fn)
+}
+
+func makeAtomic(v interface{}) interface{} {
+	return NewAtomic(v)
+}
+
+func toAtomic(box interface{}) *Atomic {
+	a, ok := box.(*Atomic)
+	if !ok {
+		TypeError("atomic", box)
+	}
+	return a
+}
+
+func atomicRef(box interface{}) interface{} {
+	return toAtomic(box).Get()
+}
+
+func atomicSet(box, v interface{}) interface{} {
+	toAtomic(box).Set(v)
+	return nil
+}
+
+func atomicSwap(box, v interface{}) interface{} {
+	return toAtomic(box).Swap(v)
+}
+
+func atomicCompareAndSwap(box, old, v interface{}) interface{} {
+	return toAtomic(box).CompareAndSwap(old, v)
 }
@@ -0,0 +1,174 @@
+package lisp
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/big"
+	"math/rand"
+	"sync"
+)
+
+/*
+	Random sources
+
+	A random source is a pseudo-random generator that can be seeded to give
+	the same numbers each time. Sources may be shared between threads.
+*/
+
+type RandomSource struct {
+	m sync.Mutex
+	r *rand.Rand
+}
+
+func NewRandomSource(seed int64) *RandomSource {
+	return &RandomSource{r: rand.New(rand.NewSource(seed))}
+}
+
+// Seeds from the operating system's generator, so that sources made this
+// way give different numbers each time the program runs.
+func newSeed() int64 {
+	var bs [8]byte
+	if _, err := crand.Read(bs[:]); err != nil {
+		SystemError(err)
+	}
+	return int64(binary.LittleEndian.Uint64(bs[:]))
+}
+
+var defaultRandomSource = NewRandomSource(newSeed())
+
+// Calls f with the generator, which must not be shared with any other
+// thread while f runs.
+func (self *RandomSource) use(f func(r *rand.Rand)) {
+	self.m.Lock()
+	defer self.m.Unlock()
+	f(self.r)
+}
+
+func (self *RandomSource) Seed(seed int64) {
+	self.use(func(r *rand.Rand) { r.Seed(seed) })
+}
+
+// A random number at least 0 and less than n, which may be any positive
+// integer or finite flonum.
+func (self *RandomSource) Random(n interface{}) interface{} {
+	var res interface{}
+	switch x := n.(type) {
+	case int:
+		if x > 0 {
+			self.use(func(r *rand.Rand) { res = int(r.Int63n(int64(x))) })
+		}
+	case *big.Int:
+		if x.Sign() > 0 {
+			self.use(func(r *rand.Rand) { res = normBig(new(big.Int).Rand(r, x)) })
+		}
+	case float64:
+		if x > 0 && !math.IsInf(x, 1) {
+			self.use(func(r *rand.Rand) {
+				// the product can round up to x, which is out of range
+				f := x
+				for f >= x {
+					f = r.Float64() * x
+				}
+				res = f
+			})
+		}
+	default:
+		TypeError("integer or flonum", n)
+	}
+	if res == nil {
+		Error(fmt.Sprintf("random bound must be positive (%v)", n))
+	}
+	return res
+}
+
+func (self *RandomSource) Shuffle(xs Vector) {
+	self.use(func(r *rand.Rand) {
+		r.Shuffle(len(xs), func(i, j int) { xs[i], xs[j] = xs[j], xs[i] })
+	})
+}
+
+func (self *RandomSource) String() string {
+	return self.GoString()
+}
+
+func (self *RandomSource) GoString() string {
+	return "#<random-source>"
+}
+
+/*
+	Random primitives
+*/
+
+func toSeed(seed interface{}) int64 {
+	switch s := seed.(type) {
+	case int:
+		return int64(s)
+	case *big.Int:
+		// only the low bits matter
+		return int64(new(big.Int).And(s, big.NewInt(1<<63-1)).Uint64())
+	}
+	TypeError("integer", seed)
+	panic("unreachable")
+}
+
+// Sources given as #f are the default source.
+func toRandomSource(source interface{}) *RandomSource {
+	if source == false {
+		return defaultRandomSource
+	}
+	s, ok := source.(*RandomSource)
+	if !ok {
+		TypeError("random-source", source)
+	}
+	return s
+}
+
+func makeRandomSource(seed interface{}) interface{} {
+	if seed == false {
+		return NewRandomSource(newSeed())
+	}
+	return NewRandomSource(toSeed(seed))
+}
+
+func randomSourceSeed(source, seed interface{}) interface{} {
+	toRandomSource(source).Seed(toSeed(seed))
+	return nil
+}
+
+func random(n, source interface{}) interface{} {
+	return toRandomSource(source).Random(n)
+}
+
+// Returns a shuffled copy of a list or vector.
+func randomShuffle(seq, source interface{}) interface{} {
+	s := toRandomSource(source)
+	if v, ok := seq.(Vector); ok {
+		res := make(Vector, len(v))
+		copy(res, v)
+		s.Shuffle(res)
+		return res
+	}
+	res := lsToVec(seq).(Vector)
+	s.Shuffle(res)
+	return vecToLs(res)
+}
+
+// Bytes from the operating system's secure generator, as a vector of
+// fixnums.
+func cryptoRandomBytes(n interface{}) interface{} {
+	l, ok := n.(int)
+	if !ok || l < 0 {
+		TypeError("non-negative fixnum", n)
+	}
+	bs := make([]byte, l)
+	if _, err := crand.Read(bs); err != nil {
+		SystemError(err)
+	}
+	res := make(Vector, l)
+	for i, b := range bs {
+		res[i] = int(b)
+	}
+	return res
+}
@@ -0,0 +1,28 @@
+package lisp
+
+import "testing"
+
+// Random numbers fall in range, and sources with the same seed agree.
+func TestRandom(t *testing.T) {
+	checkEval(t, []evalTest{
+		{"(let ([r (random 10)]) (and (>= r 0) (< r 10)))", "#t"},
+		{"(let ([r (random 1.5)]) (and (>= r 0) (< r 1.5)))", "#t"},
+		{"(< (random (expt 10 40)) (expt 10 40))", "#t"},
+		{"(random 1)", "0"},
+		{"(random 0)", "!error"},
+		{"(random 1/2)", "!type-error"},
+		{"(make-random-source 1)", "#<random-source>"},
+		{"(define a (make-random-source 42))", "#v"},
+		{"(define b (make-random-source 42))", "#v"},
+		{"(= (random 1000000 a) (random 1000000 b))", "#t"},
+		{"(define x (random 1000000 a))", "#v"},
+		{"(random-source-seed! a 42)", "#v"},
+		{"(begin (random 1000000 a) (= x (random 1000000 a)))", "#t"},
+		{"(random-source-seed! b 7)", "#v"},
+		{"(random-source-seed! a 7)", "#v"},
+		{"(equal? (random-shuffle '(1 2 3 4 5 6 7 8) a) (random-shuffle '(1 2 3 4 5 6 7 8) b))", "#t"},
+		{"(apply + (random-shuffle '(1 2 3 4 5)))", "15"},
+		{"(vector-length (crypto-random-bytes 16))", "16"},
+		{"(random 10 'foo)", "!type-error"},
+	})
+}
@@ -0,0 +1,151 @@
+package lisp
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+/*
+	Regular expressions
+
+	Regular expressions use Go's syntax. They are written #rx"...", where
+	backslashes are part of the pattern and only \" needs escaping.
+*/
+
+func compileRegexp(pattern string) *regexp.Regexp {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		Error(fmt.Sprintf("invalid regexp: %s", err))
+	}
+	return re
+}
+
+// Reads the part of a regexp literal after #rx.
+func parseRegexp(s string) *regexp.Regexp {
+	return compileRegexp(strings.Replace(s[1:len(s)-1], "\\\"", "\"", -1))
+}
+
+// Quotes are escaped unless the pattern already escapes them. Such a quote
+// reads back as an unescaped one, which matches the same.
+func formatRegexp(re *regexp.Regexp) string {
+	var buf bytes.Buffer
+	buf.WriteString("#rx\"")
+	escaped := false
+	for _, c := range re.String() {
+		if c == '"' && !escaped {
+			buf.WriteByte('\\')
+		}
+		escaped = c == '\\' && !escaped
+		buf.WriteRune(c)
+	}
+	buf.WriteByte('"')
+	return buf.String()
+}
+
+// Patterns may be given as strings, which are compiled each time.
+func toRegexp(x interface{}) *regexp.Regexp {
+	switch re := x.(type) {
+	case *regexp.Regexp:
+		return re
+	case string:
+		return compileRegexp(re)
+	}
+	TypeError("regexp", x)
+	panic("unreachable")
+}
+
+// The whole match followed by each submatch, with #f for submatches that
+// did not take part.
+func matchList(s string, loc []int) interface{} {
+	res := make(Vector, len(loc)/2)
+	for i := range res {
+		if loc[2*i] == -1 {
+			res[i] = false
+		} else {
+			res[i] = s[loc[2*i]:loc[2*i+1]]
+		}
+	}
+	return vecToLs(res)
+}
+
+/*
+	Regexp primitives
+*/
+
+func makeRegexp(pattern interface{}) interface{} {
+	return toRegexp(pattern)
+}
+
+func regexpMatches(re, str interface{}) interface{} {
+	return toRegexp(re).MatchString(toString(str))
+}
+
+func regexpMatch(re, str interface{}) interface{} {
+	s := toString(str)
+	loc := toRegexp(re).FindStringSubmatchIndex(s)
+	if loc == nil {
+		return false
+	}
+	return matchList(s, loc)
+}
+
+func regexpMatchAll(re, str interface{}) interface{} {
+	s := toString(str)
+	locs := toRegexp(re).FindAllStringSubmatchIndex(s, -1)
+	res := make(Vector, len(locs))
+	for i, loc := range locs {
+		res[i] = matchList(s, loc)
+	}
+	return vecToLs(res)
+}
+
+// An alist from the names of the named groups to what they matched.
+func regexpMatchNamed(re, str interface{}) interface{} {
+	r, s := toRegexp(re), toString(str)
+	loc := r.FindStringSubmatchIndex(s)
+	if loc == nil {
+		return false
+	}
+	subs := lsToVec(matchList(s, loc)).(Vector)
+	var res Vector
+	for i, name := range r.SubexpNames() {
+		if name != "" {
+			res = append(res, Cons(Symbol(name), subs[i]))
+		}
+	}
+	return vecToLs(res)
+}
+
+// Replaces every match. A string replacement may refer to submatches as
+// $1 or ${name}; a function is called with the match and its submatches
+// and returns the replacement.
+func regexpReplace(th *Thread, re, str, repl interface{}) interface{} {
+	r, s := toRegexp(re), toString(str)
+	switch x := repl.(type) {
+	case string:
+		return r.ReplaceAllString(s, x)
+	case Function:
+		var res strings.Builder
+		last := 0
+		for _, loc := range r.FindAllStringSubmatchIndex(s, -1) {
+			res.WriteString(s[last:loc[0]])
+			res.WriteString(toString(applyOn(th, x, matchList(s, loc))))
+			last = loc[1]
+		}
+		res.WriteString(s[last:])
+		return res.String()
+	}
+	TypeError("string or function", repl)
+	panic("unreachable")
+}
+
+func regexpSplit(re, str interface{}) interface{} {
+	ss := toRegexp(re).Split(toString(str), -1)
+	res := make(Vector, len(ss))
+	for i, s := range ss {
+		res[i] = s
+	}
+	return vecToLs(res)
+}
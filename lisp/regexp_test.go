@@ -0,0 +1,48 @@
+package lisp
+
+import (
+	"regexp"
+	"testing"
+)
+
+// Regexps are read and written with #rx syntax, and may be given as strings.
+func TestRegexps(t *testing.T) {
+	checkEval(t, []evalTest{
+		{`#rx"\d+"`, `#rx"\d+"`},
+		{`#rx"a\"b"`, `#rx"a\"b"`},
+		{`(regexp "a(")`, "!error"},
+		{`(regexp-match? #rx"^\d+$" "123")`, "#t"},
+		{`(regexp-match #rx"a(x)?b" "ab")`, `("ab" #f)`},
+		{`(regexp-match-all #rx"\d" "a1b2")`, `(("1") ("2"))`},
+		{`(regexp-match-named #rx"(?P<user>\w+)@(?P<host>\w+)" "bob@example")`, `((user . "bob") (host . "example"))`},
+		{`(regexp-replace #rx"(\w+)@(\w+)" "bob@example" "$2 at ${1}")`, `"example at bob"`},
+		{`(regexp-replace #rx"(a)(b)?" "abac" (lambda (m a b) (if b "X" "Y")))`, `"XYc"`},
+		{`(regexp-split #rx",\s*" "a, b,c")`, `("a" "b" "c")`},
+		{`(regexp-match 1 "a")`, "!type-error"},
+		{`(object->string #rx"a.b")`, `"#rx\"a.b\""`},
+	})
+}
+
+// A written regexp reads back as one that matches the same, whether or not
+// its quotes were escaped to begin with.
+func TestRegexpsReadBack(t *testing.T) {
+	tests := []struct {
+		pattern, written, match string
+	}{
+		{`a"b`, `#rx"a\"b"`, `a"b`},
+		{`a\"b`, `#rx"a\"b"`, `a"b`},
+		{`\\"`, `#rx"\\\""`, `\"`},
+		{`[\"]`, `#rx"[\"]"`, `"`},
+	}
+	for _, test := range tests {
+		written := formatRegexp(regexp.MustCompile(test.pattern))
+		if written != test.written {
+			t.Errorf("%s written as %s, want %s", test.pattern, written, test.written)
+			continue
+		}
+		re, ok := ReadString(written).(*regexp.Regexp)
+		if !ok || !re.MatchString(test.match) {
+			t.Errorf("%s does not read back to match %s", written, test.match)
+		}
+	}
+}
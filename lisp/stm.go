@@ -0,0 +1,269 @@
+package lisp
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+	Atoms
+*/
+
+// Shared state that is changed by applying functions to it, retrying if
+// another thread got there first.
+type Atom struct {
+	box Atomic
+}
+
+func NewAtom(v interface{}) *Atom {
+	res := new(Atom)
+	res.box.Set(v)
+	return res
+}
+
+func (self *Atom) Deref() interface{} {
+	return self.box.Get()
+}
+
+func (self *Atom) Reset(v interface{}) {
+	self.box.Set(v)
+}
+
+// f may be called more than once, so should not have side effects.
+func (self *Atom) Swap(th *Thread, f Function) interface{} {
+	return self.box.Update(func(x interface{}) interface{} {
+		return callOn(th, f, x)
+	})
+}
+
+func (self *Atom) CompareAndSet(old, v interface{}) bool {
+	return self.box.CompareAndSwap(old, v)
+}
+
+func (self *Atom) String() string {
+	return self.GoString()
+}
+
+func (self *Atom) GoString() string {
+	return fmt.Sprintf("#<atom %s>", toWrite("%#v", self.Deref()))
+}
+
+/*
+	Software transactional memory
+
+	Transactions read from a snapshot of the refs as of when they started and
+	buffer their writes. On commit every ref the transaction used is locked,
+	and if any of them has been changed by another transaction since it
+	started, the transaction runs again.
+*/
+
+var (
+	stmClock int64
+	refCount int64
+)
+
+const maxRetries = 10000
+
+type Ref struct {
+	id      int64
+	lock    sync.Mutex
+	val     interface{}
+	version int64
+}
+
+func NewRef(v interface{}) *Ref {
+	return &Ref{id: atomic.AddInt64(&refCount, 1), val: v}
+}
+
+func (self *Ref) read() (interface{}, int64) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.val, self.version
+}
+
+// Reads the ref, as seen by th's transaction if it has one.
+func (self *Ref) Deref(th *Thread) interface{} {
+	if th.tx != nil {
+		return th.tx.read(self)
+	}
+	v, _ := self.read()
+	return v
+}
+
+func (self *Ref) Set(th *Thread, v interface{}) {
+	if th.tx == nil {
+		Error("ref-set! outside of a transaction")
+	}
+	th.tx.writes[self] = v
+}
+
+func (self *Ref) String() string {
+	return self.GoString()
+}
+
+func (self *Ref) GoString() string {
+	v, _ := self.read()
+	return fmt.Sprintf("#<ref %s>", toWrite("%#v", v))
+}
+
+type transaction struct {
+	readPoint int64
+	reads     map[*Ref]bool
+	writes    map[*Ref]interface{}
+}
+
+type retry struct{}
+
+func (retry) escape() {}
+
+func newTransaction() *transaction {
+	return &transaction{
+		readPoint: atomic.LoadInt64(&stmClock),
+		reads:     make(map[*Ref]bool),
+		writes:    make(map[*Ref]interface{}),
+	}
+}
+
+func (self *transaction) read(r *Ref) interface{} {
+	if v, ok := self.writes[r]; ok {
+		return v
+	}
+	v, version := r.read()
+	if version > self.readPoint {
+		panic(retry{})
+	}
+	self.reads[r] = true
+	return v
+}
+
+func (self *transaction) commit() bool {
+	if len(self.writes) == 0 {
+		return true
+	}
+	// lock everything used, in a consistent order to avoid deadlock
+	refs := make([]*Ref, 0, len(self.reads)+len(self.writes))
+	for r := range self.reads {
+		refs = append(refs, r)
+	}
+	for r := range self.writes {
+		if !self.reads[r] {
+			refs = append(refs, r)
+		}
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i].id < refs[j].id })
+	for _, r := range refs {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+	}
+	for r := range self.reads {
+		if r.version > self.readPoint {
+			return false
+		}
+	}
+	version := atomic.AddInt64(&stmClock, 1)
+	for r, v := range self.writes {
+		r.val, r.version = v, version
+	}
+	return true
+}
+
+// Runs thk in a transaction, or as part of th's current one if it has one.
+func InTransaction(th *Thread, thk Function) interface{} {
+	if th.tx != nil {
+		return callOn(th, thk)
+	}
+	for i := 0; i < maxRetries; i++ {
+		tx := newTransaction()
+		res, ok := tx.run(th, thk)
+		if ok && tx.commit() {
+			return res
+		}
+		runtime.Gosched()
+	}
+	Error("transaction retried too many times")
+	panic("unreachable")
+}
+
+// Reports false if the transaction needs to be retried.
+func (self *transaction) run(th *Thread, thk Function) (res interface{}, ok bool) {
+	th.tx = self
+	defer func() {
+		th.tx = nil
+		if err := recover(); err != nil {
+			if _, retrying := err.(retry); !retrying {
+				panic(err)
+			}
+		}
+	}()
+	return callOn(th, thk), true
+}
+
+/*
+	Atom and ref primitives
+*/
+
+func atom(v interface{}) interface{} {
+	return NewAtom(v)
+}
+
+func toAtom(a interface{}) *Atom {
+	res, ok := a.(*Atom)
+	if !ok {
+		TypeError("atom", a)
+	}
+	return res
+}
+
+func deref(th *Thread, x interface{}) interface{} {
+	switch r := x.(type) {
+	case *Atom:
+		return r.Deref()
+	case *Ref:
+		return r.Deref(th)
+	case *Future:
+		return r.Touch()
+	}
+	TypeError("reference", x)
+	panic("unreachable")
+}
+
+func reset(a, v interface{}) interface{} {
+	toAtom(a).Reset(v)
+	return v
+}
+
+func swap(th *Thread, a, f interface{}) interface{} {
+	fn, ok := f.(Function)
+	if !ok {
+		TypeError("function", f)
+	}
+	return toAtom(a).Swap(th, fn)
+}
+
+func compareAndSet(a, old, v interface{}) interface{} {
+	return toAtom(a).CompareAndSet(old, v)
+}
+
+func ref(v interface{}) interface{} {
+	return NewRef(v)
+}
+
+func refSet(th *Thread, r, v interface{}) interface{} {
+	res, ok := r.(*Ref)
+	if !ok {
+		TypeError("ref", r)
+	}
+	res.Set(th, v)
+	return v
+}
+
+func callInTransaction(th *Thread, thk interface{}) interface{} {
+	t, ok := thk.(Function)
+	if !ok {
+		TypeError("function", thk)
+	}
+	return InTransaction(th, t)
+}
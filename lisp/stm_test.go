@@ -0,0 +1,35 @@
+package lisp
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Each transaction reads the ref that the other writes, so a commit that
+// locks only the refs it writes can deadlock with the other.
+func TestCrossedTransactions(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	x, y := NewRef(0), NewRef(0)
+	cross := func(from, to *Ref) {
+		th := mainThread()
+		for i := 0; i < 100000; i++ {
+			InTransaction(th, threadPrimitive(func(th *Thread, _ interface{}) interface{} {
+				to.Set(th, from.Deref(th).(int)+1)
+				return nil
+			}))
+		}
+	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() { defer wg.Done(); cross(x, y) }()
+	go func() { defer wg.Done(); cross(y, x) }()
+	done := make(chan struct{})
+	go func() { wg.Wait(); close(done) }()
+	select {
+	case <-done:
+	case <-time.After(20 * time.Second):
+		t.Fatal("transactions deadlocked")
+	}
+}
@@ -0,0 +1,19 @@
+package lisp
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// Runs the golisp concurrency stress suite. Run with -race to check that
+// environments shared between threads are accessed safely.
+func TestConcurrentEnvironments(t *testing.T) {
+	PreludePaths = []string{filepath.Join("..", PreludeFile)}
+	i := New()
+	defer func() {
+		if err := recover(); err != nil {
+			t.Fatal(toWrite("%v", err))
+		}
+	}()
+	i.Load(filepath.Join("testdata", "stress.golisp"))
+}
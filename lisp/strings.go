@@ -0,0 +1,252 @@
+package lisp
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+/*
+	Strings
+
+	Strings are immutable Go strings. Their indices count characters, not
+	bytes.
+*/
+
+func toString(x interface{}) string {
+	s, ok := x.(string)
+	if !ok {
+		TypeError("string", x)
+	}
+	return s
+}
+
+func toIndex(idx interface{}, limit int) int {
+	i, ok := idx.(int)
+	if !ok {
+		TypeError("fixnum", idx)
+	}
+	if i < 0 || i > limit {
+		Error(fmt.Sprintf("invalid index (%v)", i))
+	}
+	return i
+}
+
+// Characters may be matched by a char, a predicate called on th, or, if m
+// is #f, by dflt.
+func toMatcher(th *Thread, m interface{}, dflt func(rune) bool) func(rune) bool {
+	switch x := m.(type) {
+	case rune:
+		return func(r rune) bool { return r == x }
+	case Function:
+		return func(r rune) bool { return callOn(th, x, r) != false }
+	}
+	if m == false && dflt != nil {
+		return dflt
+	}
+	TypeError("char or function", m)
+	panic("unreachable")
+}
+
+// Converts a byte offset into s to a character index.
+func charIndex(s string, i int) interface{} {
+	if i == -1 {
+		return false
+	}
+	return utf8.RuneCountInString(s[:i])
+}
+
+// Converts a character index into s to a byte offset, walking the string
+// rather than decoding all of it.
+func byteOffset(s string, i int) int {
+	off := 0
+	for ; i > 0; i-- {
+		_, size := utf8.DecodeRuneInString(s[off:])
+		off += size
+	}
+	return off
+}
+
+func foldcase(s string) string {
+	return strings.Map(charFoldcase, s)
+}
+
+/*
+	String primitives
+*/
+
+func stringLength(str interface{}) interface{} {
+	return utf8.RuneCountInString(toString(str))
+}
+
+func stringRef(str, idx interface{}) interface{} {
+	s := toString(str)
+	i := toIndex(idx, utf8.RuneCountInString(s)-1)
+	r, _ := utf8.DecodeRuneInString(s[byteOffset(s, i):])
+	return r
+}
+
+// The end of the substring is the end of the string if it is #f.
+func substring(str, start, end interface{}) interface{} {
+	s := toString(str)
+	hi := utf8.RuneCountInString(s)
+	if end != false {
+		hi = toIndex(end, hi)
+	}
+	lo := toIndex(start, hi)
+	off := byteOffset(s, lo)
+	return s[off : off+byteOffset(s[off:], hi-lo)]
+}
+
+func stringIndex(th *Thread, str, m interface{}) interface{} {
+	s := toString(str)
+	return charIndex(s, strings.IndexFunc(s, toMatcher(th, m, nil)))
+}
+
+func stringContains(str, sub interface{}) interface{} {
+	s := toString(str)
+	return charIndex(s, strings.Index(s, toString(sub)))
+}
+
+func stringPrefix(prefix, str interface{}) interface{} {
+	return strings.HasPrefix(toString(str), toString(prefix))
+}
+
+func stringSuffix(suffix, str interface{}) interface{} {
+	return strings.HasSuffix(toString(str), toString(suffix))
+}
+
+func stringUpcase(str interface{}) interface{} {
+	return strings.ToUpper(toString(str))
+}
+
+func stringDowncase(str interface{}) interface{} {
+	return strings.ToLower(toString(str))
+}
+
+func stringFoldcase(str interface{}) interface{} {
+	return foldcase(toString(str))
+}
+
+// Trimmed characters are whitespace unless something else is given.
+func stringTrim(th *Thread, str, m interface{}) interface{} {
+	return strings.TrimFunc(toString(str), toMatcher(th, m, unicode.IsSpace))
+}
+
+func stringTrimLeft(th *Thread, str, m interface{}) interface{} {
+	return strings.TrimLeftFunc(toString(str), toMatcher(th, m, unicode.IsSpace))
+}
+
+func stringTrimRight(th *Thread, str, m interface{}) interface{} {
+	return strings.TrimRightFunc(toString(str), toMatcher(th, m, unicode.IsSpace))
+}
+
+// Gives the string, its length in characters, the length to pad it to and
+// the padding.
+func padArgs(str, n, c interface{}) (string, int, int, string) {
+	s := toString(str)
+	l, ok := n.(int)
+	if !ok || l < 0 {
+		TypeError("non-negative fixnum", n)
+	}
+	pad := " "
+	if c != false {
+		pad = string(toChar(c))
+	}
+	return s, utf8.RuneCountInString(s), l, pad
+}
+
+// Pads on the left to n characters, or keeps the rightmost n if the string
+// is longer.
+func stringPad(str, n, c interface{}) interface{} {
+	s, count, l, pad := padArgs(str, n, c)
+	if count >= l {
+		return s[byteOffset(s, count-l):]
+	}
+	return strings.Repeat(pad, l-count) + s
+}
+
+func stringPadRight(str, n, c interface{}) interface{} {
+	s, count, l, pad := padArgs(str, n, c)
+	if count >= l {
+		return s[:byteOffset(s, l)]
+	}
+	return s + strings.Repeat(pad, l-count)
+}
+
+// Replaces every occurrence of old with new.
+func stringReplace(str, old, new interface{}) interface{} {
+	return strings.Replace(toString(str), toString(old), toString(new), -1)
+}
+
+func stringReverse(str interface{}) interface{} {
+	s := toString(str)
+	var res strings.Builder
+	res.Grow(len(s))
+	for len(s) > 0 {
+		_, size := utf8.DecodeLastRuneInString(s)
+		res.WriteString(s[len(s)-size:])
+		s = s[:len(s)-size]
+	}
+	return res.String()
+}
+
+func stringChain(name string, args []interface{}, fold bool, test func(c int) bool) interface{} {
+	if len(args) == 0 {
+		ArgumentError(Symbol(name), EMPTY_LIST)
+	}
+	ss := make([]string, len(args))
+	for i, x := range args {
+		ss[i] = toString(x)
+		if fold {
+			ss[i] = foldcase(ss[i])
+		}
+	}
+	for i := 1; i < len(ss); i++ {
+		if !test(strings.Compare(ss[i-1], ss[i])) {
+			return false
+		}
+	}
+	return true
+}
+
+func stringEq(args ...interface{}) interface{} {
+	return stringChain("string=?", args, false, func(c int) bool { return c == 0 })
+}
+
+func stringLt(args ...interface{}) interface{} {
+	return stringChain("string<?", args, false, func(c int) bool { return c < 0 })
+}
+
+func stringGt(args ...interface{}) interface{} {
+	return stringChain("string>?", args, false, func(c int) bool { return c > 0 })
+}
+
+func stringLe(args ...interface{}) interface{} {
+	return stringChain("string<=?", args, false, func(c int) bool { return c <= 0 })
+}
+
+func stringGe(args ...interface{}) interface{} {
+	return stringChain("string>=?", args, false, func(c int) bool { return c >= 0 })
+}
+
+func stringCiEq(args ...interface{}) interface{} {
+	return stringChain("string-ci=?", args, true, func(c int) bool { return c == 0 })
+}
+
+func stringCiLt(args ...interface{}) interface{} {
+	return stringChain("string-ci<?", args, true, func(c int) bool { return c < 0 })
+}
+
+func stringCiGt(args ...interface{}) interface{} {
+	return stringChain("string-ci>?", args, true, func(c int) bool { return c > 0 })
+}
+
+func stringCiLe(args ...interface{}) interface{} {
+	return stringChain("string-ci<=?", args, true, func(c int) bool { return c <= 0 })
+}
+
+func stringCiGe(args ...interface{}) interface{} {
+	return stringChain("string-ci>=?", args, true, func(c int) bool { return c >= 0 })
+}
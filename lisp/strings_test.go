@@ -0,0 +1,30 @@
+package lisp
+
+import "testing"
+
+// Strings are indexed by rune, whatever their encoding takes in bytes.
+func TestStrings(t *testing.T) {
+	checkEval(t, []evalTest{
+		{`(string-length "héllo")`, "5"},
+		{`(string-ref "héllo" 1)`, `#\é`},
+		{`(string-ref "héllo" 4)`, `#\o`},
+		{`(string-ref "héllo" 5)`, "!error: invalid index"},
+		{`(substring "héllo wörld" 1 8)`, `"éllo wö"`},
+		{`(substring "héllo" 5)`, `""`},
+		{`(substring "héllo" 2 1)`, "!error: invalid index"},
+		{`(string-index "héllo" #\l)`, "2"},
+		{`(string-index "heLlo" char-upper-case?)`, "2"},
+		{`(string-contains "héllo wörld" "wö")`, "6"},
+		{`(string-upcase "héllo")`, `"HÉLLO"`},
+		{`(string-foldcase "Straße")`, `"straße"`},
+		{`(string-trim "12ab34" char-numeric?)`, `"ab"`},
+		{`(string-pad "hé" 4)`, `"  hé"`},
+		{`(string-pad "héllo" 3)`, `"llo"`},
+		{`(string-pad-right "é" 3 #\ö)`, `"éöö"`},
+		{`(string-replace "a-b-c" "-" "+")`, `"a+b+c"`},
+		{`(string-reverse "héllo")`, `"olléh"`},
+		{`(string<? "abc" "abd" "b")`, "#t"},
+		{`(string-ci=? "ABC" "abc")`, "#t"},
+		{`(string=? "a" 1)`, "!type-error"},
+	})
+}
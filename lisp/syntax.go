@@ -3,7 +3,9 @@ package lisp
 import (
 	"fmt"
 	"io"
+	"math"
 	"math/big"
+	"regexp"
 	"strconv"
 	"strings"
 
@@ -20,6 +22,11 @@ const (
 	_VSTART
 	_INT
 	_FLOAT
+	_RATIO
+	_LONG
+	_COMPLEX
+	_CHAR
+	_REGEXP
 	_STR
 	_COMMENT
 	_WS
@@ -36,7 +43,12 @@ var lex = lexer.RegexSet{
 	rune(_LEND2):   "\\]",
 	rune(_VSTART):  "#\\(",
 	rune(_INT):     "-?\\d+",
-	rune(_FLOAT):   "-?\\d+(\\.\\d+)?",
+	rune(_FLOAT):   "(-?\\d+(\\.\\d+)?([eE][-+]?\\d+)?|[-+](inf|nan)\\.0)",
+	rune(_RATIO):   "-?\\d+/\\d+",
+	rune(_LONG):    "-?\\d+(\\.\\d+)?[lL][-+]?\\d+",
+	rune(_COMPLEX): "((-?\\d+(\\.\\d+)?([eE][-+]?\\d+)?|[-+](inf|nan)\\.0)?[-+](\\d+(\\.\\d+)?([eE][-+]?\\d+)?|(inf|nan)\\.0)?i|(-?\\d+(\\.\\d+)?([eE][-+]?\\d+)?|[-+](inf|nan)\\.0)@(-?\\d+(\\.\\d+)?([eE][-+]?\\d+)?|[-+](inf|nan)\\.0))",
+	rune(_CHAR):    "#\\\\(x[0-9a-fA-F]+|[a-zA-Z]+|.)",
+	rune(_REGEXP):  "#rx\"([^\"\\\\]|\\\\.)*\"",
 	rune(_STR):     "\"([^\"]|\\.)*\"",
 	rune(_COMMENT): ";[^\n]*",
 	rune(_WS):      "\\s+",
@@ -99,12 +111,53 @@ var syntax = func() *peg.ExtensibleExpr {
 			return res
 		}),
 		peg.Bind(_FLOAT, func(x interface{}) interface{} {
-			res, err := strconv.ParseFloat(x.(string), 64)
+			s := x.(string)
+			switch s {
+			case "+inf.0":
+				return math.Inf(1)
+			case "-inf.0":
+				return math.Inf(-1)
+			case "+nan.0", "-nan.0":
+				return math.NaN()
+			}
+			res, err := strconv.ParseFloat(s, 64)
 			if err != nil {
 				SystemError(err)
 			}
 			return res
 		}),
+		peg.Bind(_RATIO, func(x interface{}) interface{} {
+			res, ok := new(big.Rat).SetString(x.(string))
+			if !ok {
+				Error(fmt.Sprintf("invalid ratio: %s", x))
+			}
+			return normRat(res)
+		}),
+		peg.Bind(_LONG, func(x interface{}) interface{} {
+			// the reader has no thread, so uses the default precision
+			res, ok := parseBigfloat(nil, x.(string))
+			if !ok {
+				Error(fmt.Sprintf("invalid bigfloat: %s", x))
+			}
+			return res
+		}),
+		peg.Bind(_COMPLEX, func(x interface{}) interface{} {
+			res, ok := parseComplex(x.(string))
+			if !ok {
+				Error(fmt.Sprintf("invalid complex number: %s", x))
+			}
+			return normComplex(res)
+		}),
+		peg.Bind(_CHAR, func(x interface{}) interface{} {
+			res, ok := parseChar(x.(string)[2:])
+			if !ok {
+				SyntaxError(fmt.Sprintf("unknown character: %s", x))
+			}
+			return res
+		}),
+		peg.Bind(_REGEXP, func(x interface{}) interface{} {
+			return parseRegexp(x.(string)[3:])
+		}),
 		peg.Bind(_STR, func(x interface{}) interface{} {
 			res, err := strconv.Unquote(x.(string))
 			if err != nil {
@@ -155,17 +208,19 @@ var syntax = func() *peg.ExtensibleExpr {
 	return expr
 }()
 
-func readExpr(expr peg.Expr, port interface{}) interface{} {
+func readExpr(th *Thread, expr peg.Expr, port interface{}) interface{} {
 	p, ok := port.(*InputPort)
 	if !ok {
 		TypeError("input-port", port)
 	}
-	if p.Eof() {
+	p.lockOn(th)
+	defer p.Unlock()
+	if p.eof {
 		return EOF_OBJECT
 	}
 	l := lexer.New()
 	l.Regexes(nil, lex)
-	src := peg.NewLex(p, l, func(id int) bool {
+	src := peg.NewLex(unlockedInput{p}, l, func(id int) bool {
 		return id != int(_WS) && id != int(_COMMENT)
 	})
 	m, d := expr.Match(src)
@@ -176,7 +231,14 @@ func readExpr(expr peg.Expr, port interface{}) interface{} {
 }
 
 func Read(port interface{}) interface{} {
+	return readOn(nil, port)
+}
+
+// Reads on behalf of th, so that a thread holding the port's lock may still
+// read from it.
+func readOn(th *Thread, port interface{}) interface{} {
 	return readExpr(
+		th,
 		peg.Or{
 			syntax,
 			peg.Bind(peg.Eof, func(x interface{}) interface{} { return EOF_OBJECT }),
@@ -186,7 +248,12 @@ func Read(port interface{}) interface{} {
 }
 
 func ReadFile(port interface{}) interface{} {
+	return readFileOn(nil, port)
+}
+
+func readFileOn(th *Thread, port interface{}) interface{} {
 	return readExpr(
+		th,
 		peg.Select(peg.And{
 			peg.Bind(peg.Repeat(syntax), func(x interface{}) interface{} {
 				return vecToLs(Vector(x.([]interface{})))
@@ -214,6 +281,21 @@ func toWrite(def string, obj interface{}) string {
 		}
 	case *big.Int:
 		return x.String()
+	case *big.Rat:
+		return x.RatString()
+	case float64:
+		return formatFlonum(x)
+	case *big.Float:
+		return formatBigfloat(x)
+	case complex128:
+		return formatComplex(x)
+	case *regexp.Regexp:
+		return formatRegexp(x)
+	case rune:
+		if def == "%v" {
+			return string(x)
+		}
+		return formatChar(x)
 	case *InputPort:
 		return "#<input-port>"
 	case *OutputPort:
@@ -222,20 +304,51 @@ func toWrite(def string, obj interface{}) string {
 	return fmt.Sprintf(def, obj)
 }
 
-func Write(obj, port interface{}) interface{} {
-	p, ok := port.(io.Writer)
-	if !ok {
+// Flonums always have a decimal point or exponent, so they read back as
+// flonums.
+func formatFlonum(x float64) string {
+	switch {
+	case math.IsNaN(x):
+		return "+nan.0"
+	case math.IsInf(x, 1):
+		return "+inf.0"
+	case math.IsInf(x, -1):
+		return "-inf.0"
+	}
+	res := strconv.FormatFloat(x, 'g', -1, 64)
+	if !strings.ContainsAny(res, ".e") {
+		res += ".0"
+	}
+	return res
+}
+
+// Ports are written to on behalf of th, so that a thread holding a port's
+// lock may still write to it.
+func writeTo(th *Thread, port interface{}, s string) {
+	switch p := port.(type) {
+	case *OutputPort:
+		p.writeStringOn(th, s)
+	case io.Writer:
+		io.WriteString(p, s)
+	default:
 		TypeError("output-port", port)
 	}
-	io.WriteString(p, toWrite("%#v", obj))
+}
+
+func Write(obj, port interface{}) interface{} {
+	return writeOn(nil, obj, port)
+}
+
+func writeOn(th *Thread, obj, port interface{}) interface{} {
+	writeTo(th, port, toWrite("%#v", obj))
 	return nil
 }
 
 func Display(obj, port interface{}) interface{} {
-	p, ok := port.(io.Writer)
-	if !ok {
-		TypeError("output-port", port)
-	}
-	io.WriteString(p, toWrite("%v", obj))
+	return displayOn(nil, obj, port)
+}
+
+func displayOn(th *Thread, obj, port interface{}) interface{} {
+	writeTo(th, port, toWrite("%v", obj))
 	return nil
 }